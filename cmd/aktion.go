@@ -17,23 +17,33 @@ limitations under the License.
 package cmd
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/actions/workflow-parser/model"
 	"github.com/actions/workflow-parser/parser"
 	"github.com/ghodss/yaml"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 var (
-	version    string
-	filename   string
-	outputType string
-	kubeConfig string
-	namespace  string
-	repo       string
+	version           string
+	filename          string
+	outputType        string
+	kubeConfig        string
+	kubeContext       string
+	impersonate       string
+	impersonateGroups []string
+	clientQPS         float32
+	clientBurst       int
+	requestTimeout    time.Duration
+	namespace         string
+	repo              string
 )
 
 var aktionCmd = &cobra.Command{
@@ -91,6 +101,18 @@ func GenerateObjLastBreak() string {
 	}
 }
 
+//PrintObjects prints the objects separated by the object breaks of the output type
+func PrintObjects(objects []runtime.Object) {
+	fmt.Printf("%s", GenerateObjBreak(true))
+	for i, obj := range objects {
+		if i > 0 {
+			fmt.Printf("%s", GenerateObjBreak(false))
+		}
+		fmt.Printf("%s", GenerateOutput(obj))
+	}
+	fmt.Printf("%s", GenerateObjLastBreak())
+}
+
 //ParseData parses Github Action Workflow File into Configuration object
 func ParseData() *model.Configuration {
 	if filename == "" {
@@ -99,17 +121,19 @@ func ParseData() *model.Configuration {
 		os.Exit(1)
 	}
 
-	f, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filename)
 
 	if err != nil {
 		Panic("Error opening file: %s\n", err)
 	}
 
-	config, err := parser.Parse(f)
+	config, err := parser.Parse(bytes.NewReader(data))
 	if err != nil {
 		Panic("Error parsing file: %s\n", err)
 	}
-	_ = f.Close()
+
+	workflowHashes = hashWorkflows(config)
+	sourceFile = sourcePath(filename)
 
 	return config
 }
@@ -135,14 +159,29 @@ func init() {
 	aktionCmd.PersistentFlags().StringVarP(&filename, "filename", "f", "", "Github Action Workflow File")
 	aktionCmd.PersistentFlags().StringVarP(&outputType, "output", "o", "yaml", "Output type for the results (json|yaml)")
 	aktionCmd.PersistentFlags().StringVarP(&kubeConfig, "kubeconfig", "k", "", "Kubernetes config file")
+	aktionCmd.PersistentFlags().StringVarP(&kubeContext, "context", "", "", "Kubernetes config context to use instead of the current one")
+	aktionCmd.PersistentFlags().StringVarP(&impersonate, "as", "", "", "User to impersonate for Kubernetes requests")
+	aktionCmd.PersistentFlags().StringSliceVarP(&impersonateGroups, "as-group", "", nil, "Groups to impersonate for Kubernetes requests, can be repeated")
+	aktionCmd.PersistentFlags().Float32VarP(&clientQPS, "qps", "", 0, "Maximum queries per second to the Kubernetes API server, 0 for the client default")
+	aktionCmd.PersistentFlags().IntVarP(&clientBurst, "burst", "", 0, "Maximum burst of queries to the Kubernetes API server, 0 for the client default")
+	aktionCmd.PersistentFlags().DurationVarP(&requestTimeout, "request-timeout", "", 0, "Timeout of a single Kubernetes request, 0 for no timeout. Watches and log streams are not limited")
 	aktionCmd.PersistentFlags().StringVarP(&namespace, "namespace", "n", "default", "Kubernetes namespace")
 	aktionCmd.PersistentFlags().StringVarP(&repo, "git", "g", "", "Git repository")
+	aktionCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Aktion config file with the build options of actions")
 	aktionCmd.AddCommand(versionCmd)
 	aktionCmd.AddCommand(NewParserCmd())
 	aktionCmd.AddCommand(NewCreateCmd(&kubeConfig, &namespace, &repo))
-	aktionCmd.AddCommand(NewLaunchCmd(&repo))
+	aktionCmd.AddCommand(NewDeleteCmd(&kubeConfig, &namespace))
+	aktionCmd.AddCommand(NewDiffCmd(&kubeConfig, &namespace, &repo))
+	aktionCmd.AddCommand(NewRunCmd(&kubeConfig, &namespace, &repo))
+	aktionCmd.AddCommand(NewLogsCmd(&kubeConfig, &namespace, &repo))
+	aktionCmd.AddCommand(NewStatusCmd(&kubeConfig, &namespace, &repo))
+	aktionCmd.AddCommand(NewHistoryCmd(&kubeConfig, &namespace, &repo))
+	aktionCmd.AddCommand(NewCancelCmd(&kubeConfig, &namespace))
+	aktionCmd.AddCommand(NewRerunCmd(&kubeConfig, &namespace, &repo))
+	aktionCmd.AddCommand(NewLaunchCmd(&kubeConfig, &namespace, &repo))
 }
 
 func initConfig() {
-	// do nothing for now
+	loadConfig()
 }
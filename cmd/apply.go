@@ -0,0 +1,509 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/triggermesh/aktion/pkg/client"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+)
+
+// applyResult tells what applying an object changed in the cluster
+type applyResult string
+
+const (
+	resultCreated   applyResult = "created"
+	resultUpdated   applyResult = "updated"
+	resultUnchanged applyResult = "unchanged"
+)
+
+// Values of the --dry-run flag
+const (
+	dryRunNone   = "none"
+	dryRunClient = "client"
+	dryRunServer = "server"
+)
+
+// dryRunAll is the value of the dryRun query parameter that skips persisting the request
+const dryRunAll = "All"
+
+// applier creates or updates generated objects in a namespace
+type applier struct {
+	client    dynamic.Interface
+	rest      rest.Interface
+	namespace string
+	dryRun    bool
+	changes   []change
+}
+
+// change records an object changed by the applier, so that it can be rolled back
+type change struct {
+	resource dynamic.ResourceInterface
+	ref      string
+	name     string
+	// previous is the object before the change, nil if the object was created
+	previous *unstructured.Unstructured
+	deleted  bool
+}
+
+// newClientSet connects to the cluster described by the kubeconfig file and the connection flags,
+// panic otherwise
+func newClientSet(kubeConfig string) client.ConfigSet {
+	clientSet, err := client.NewClientWithOptions(client.Options{
+		ConfigFile:        kubeConfig,
+		Context:           kubeContext,
+		Impersonate:       impersonate,
+		ImpersonateGroups: impersonateGroups,
+		QPS:               clientQPS,
+		Burst:             clientBurst,
+		Timeout:           requestTimeout,
+	})
+	if err != nil {
+		Panic("Error connecting to kubernetes cluster: %s\n", err)
+	}
+
+	return clientSet
+}
+
+// newApplier returns an applier for the namespace flag, submitting requests as server-side
+// dry runs when the --dry-run flag is set to server
+func newApplier(kubeConfig string) *applier {
+	return applierFor(newClientSet(kubeConfig))
+}
+
+// applierFor returns an applier using an existing client set
+func applierFor(clientSet client.ConfigSet) *applier {
+	return &applier{
+		client:    clientSet.Dynamic,
+		rest:      clientSet.Core.CoreV1().RESTClient(),
+		namespace: namespace,
+		dryRun:    dryRunMode == dryRunServer,
+	}
+}
+
+// applyPipeline applies the generated objects of every workflow as a single transaction: when
+// an object cannot be applied, everything changed so far is rolled back
+func applyPipeline(kubeConfig string, workflows []string, objects map[string][]runtime.Object, prune bool) {
+	a := newApplier(kubeConfig)
+
+	for _, w := range workflows {
+		if err := a.applyWorkflow(w, objects[w], prune); err != nil {
+			a.rollback()
+			Panic("Unable to apply workflow %s: %s\n", w, err)
+		}
+	}
+}
+
+// applyWorkflow creates or updates every generated object of a workflow and reports the result per object.
+// With prune, objects previously generated for the workflow that are no longer part of it are deleted.
+// In server-side dry run mode every object is submitted and all rejections are reported.
+func (a *applier) applyWorkflow(workflow string, objects []runtime.Object, prune bool) error {
+	keep := make(map[string]bool)
+	rejected := 0
+	for _, obj := range objects {
+		u, err := toUnstructured(obj)
+		if err != nil {
+			return fmt.Errorf("converting %s: %s", obj.GetObjectKind().GroupVersionKind().Kind, err)
+		}
+
+		result, err := a.apply(u)
+		if err != nil && a.dryRun {
+			fmt.Printf("%s rejected: %s\n", objectRef(u), err)
+			rejected++
+			continue
+		} else if err != nil {
+			return fmt.Errorf("applying %s: %s", objectRef(u), err)
+		}
+
+		fmt.Printf("%s %s%s\n", objectRef(u), result, a.suffix())
+		if u.GetGenerateName() == "" {
+			keep[resourceKey(resourceName(u), u.GetName())] = true
+		}
+	}
+
+	if rejected > 0 {
+		return fmt.Errorf("%d objects were rejected by the server", rejected)
+	}
+
+	if prune {
+		if err := a.deleteWorkflow(workflow, prunedResources, keep); err != nil {
+			return fmt.Errorf("pruning: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// rollback undoes the changes recorded by the applier in reverse order and reports each of them
+func (a *applier) rollback() {
+	for i := len(a.changes) - 1; i >= 0; i-- {
+		c := a.changes[i]
+
+		var undone string
+		var err error
+		if c.previous == nil {
+			undone = "deleted"
+			err = c.resource.Delete(c.name, &metav1.DeleteOptions{})
+		} else if c.deleted {
+			undone = "recreated"
+			_, err = c.resource.Create(restorable(c.previous))
+		} else {
+			undone = "restored"
+			err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				current, err := c.resource.Get(c.name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+
+				previous := restorable(c.previous)
+				previous.SetResourceVersion(current.GetResourceVersion())
+				_, err = c.resource.Update(previous)
+				return err
+			})
+		}
+
+		if err != nil {
+			fmt.Printf("%s rollback failed: %s\n", c.ref, err)
+		} else {
+			fmt.Printf("%s %s (rolled back)\n", c.ref, undone)
+		}
+	}
+
+	a.changes = nil
+}
+
+// restorable returns a copy of a previously read object without the fields set by the server
+func restorable(u *unstructured.Unstructured) *unstructured.Unstructured {
+	r := u.DeepCopy()
+	for _, field := range []string{"resourceVersion", "uid", "selfLink", "generation", "creationTimestamp"} {
+		unstructured.RemoveNestedField(r.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(r.Object, "status")
+
+	return r
+}
+
+// record adds a change to the rollback journal, dry runs do not change anything
+func (a *applier) record(res dynamic.ResourceInterface, ref string, name string, previous *unstructured.Unstructured, deleted bool) {
+	if a.dryRun {
+		return
+	}
+
+	a.changes = append(a.changes, change{
+		resource: res,
+		ref:      ref,
+		name:     name,
+		previous: previous,
+		deleted:  deleted,
+	})
+}
+
+// apply creates the object if it does not exist yet and updates it if the live version differs
+func (a *applier) apply(desired *unstructured.Unstructured) (applyResult, error) {
+	res := a.resource(desired)
+
+	if desired.GetGenerateName() != "" {
+		return a.create(res, desired)
+	}
+
+	live, err := res.Get(desired.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if a.dryRun {
+			err = a.submitDryRun(a.rest.Post(), desired, false)
+		} else {
+			_, err = res.Create(desired)
+		}
+		if err != nil {
+			return "", err
+		}
+		a.record(res, objectRef(desired), desired.GetName(), nil, false)
+		return resultCreated, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if isUnchanged(desired, live) {
+		return resultUnchanged, nil
+	}
+
+	if a.dryRun {
+		if err = a.submitDryRun(a.rest.Put(), mergeLive(desired, live), true); err != nil {
+			return "", err
+		}
+		return resultUpdated, nil
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live, err = res.Get(desired.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		_, err = res.Update(mergeLive(desired, live))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	a.record(res, objectRef(desired), desired.GetName(), live, false)
+
+	return resultUpdated, nil
+}
+
+// create creates an object named by the server, such as a PipelineRun. Each call starts a new
+// object, so it is never compared with live ones. It is recorded by the name the server gave it,
+// so that a rollback deletes it.
+func (a *applier) create(res dynamic.ResourceInterface, desired *unstructured.Unstructured) (applyResult, error) {
+	if a.dryRun {
+		if err := a.submitDryRun(a.rest.Post(), desired, false); err != nil {
+			return "", err
+		}
+		return resultCreated, nil
+	}
+
+	created, err := res.Create(desired)
+	if err != nil {
+		return "", err
+	}
+	desired.SetName(created.GetName())
+	a.record(res, objectRef(desired), created.GetName(), nil, false)
+
+	return resultCreated, nil
+}
+
+// submitDryRun sends the object with the dryRun parameter, so that admission webhooks and
+// validation run against it without anything being persisted
+func (a *applier) submitDryRun(req *rest.Request, u *unstructured.Unstructured, named bool) error {
+	body, err := u.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	path := resourcePath(u.GroupVersionKind().GroupVersion().WithResource(resourceName(u)), a.namespace)
+	if named {
+		path = append(path, u.GetName())
+	}
+
+	return req.AbsPath(path...).
+		Param("dryRun", dryRunAll).
+		SetHeader("Content-Type", "application/json").
+		Body(body).
+		Do().
+		Error()
+}
+
+// suffix returns the note appended to reported results
+func (a *applier) suffix() string {
+	if a.dryRun {
+		return " (server dry run)"
+	}
+
+	return ""
+}
+
+// resource returns the dynamic client for the object's kind in the applier namespace
+func (a *applier) resource(u *unstructured.Unstructured) dynamic.ResourceInterface {
+	gvr, _ := meta.UnsafeGuessKindToResource(u.GroupVersionKind())
+	return a.client.Resource(gvr).Namespace(a.namespace)
+}
+
+// resourceName returns the plural resource name of the object's kind
+func resourceName(u *unstructured.Unstructured) string {
+	gvr, _ := meta.UnsafeGuessKindToResource(u.GroupVersionKind())
+	return gvr.Resource
+}
+
+// resourcePath returns the API path of a namespaced resource
+func resourcePath(gvr schema.GroupVersionResource, namespace string) []string {
+	if gvr.Group == "" {
+		return []string{"/api", gvr.Version, "namespaces", namespace, gvr.Resource}
+	}
+
+	return []string{"/apis", gvr.Group, gvr.Version, "namespaces", namespace, gvr.Resource}
+}
+
+// toUnstructured converts a generated object and drops the fields owned by the server
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &unstructured.Unstructured{Object: content}
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "status")
+
+	return u, nil
+}
+
+// objectRef returns the kind/name reference used when reporting on an object
+func objectRef(u *unstructured.Unstructured) string {
+	return strings.ToLower(u.GetKind()) + "/" + objectName(u)
+}
+
+// objectName returns the name of the object, or its name prefix when the server has not named it yet
+func objectName(u *unstructured.Unstructured) string {
+	if u.GetName() == "" {
+		return u.GetGenerateName()
+	}
+
+	return u.GetName()
+}
+
+// mergeLive prepares desired for an update of live, keeping the labels, annotations and
+// ServiceAccount secrets that were added to the live object by someone else
+func mergeLive(desired *unstructured.Unstructured, live *unstructured.Unstructured) *unstructured.Unstructured {
+	u := mergeSecrets(desired, live)
+	u.SetResourceVersion(live.GetResourceVersion())
+	u.SetLabels(mergeStringMaps(live.GetLabels(), desired.GetLabels()))
+	u.SetAnnotations(mergeStringMaps(live.GetAnnotations(), desired.GetAnnotations()))
+
+	return u
+}
+
+// mergeSecrets returns a copy of desired that lists the secrets of a live ServiceAccount followed
+// by the desired ones it lacks. The secrets are a set, and the token controller adds its own to them.
+func mergeSecrets(desired *unstructured.Unstructured, live *unstructured.Unstructured) *unstructured.Unstructured {
+	u := desired.DeepCopy()
+	if desired.GetKind() != "ServiceAccount" {
+		return u
+	}
+
+	secrets, _, _ := unstructured.NestedSlice(live.Object, "secrets")
+	wanted, _, _ := unstructured.NestedSlice(desired.Object, "secrets")
+	for _, w := range wanted {
+		found := false
+		for _, l := range secrets {
+			if reflect.DeepEqual(w, l) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			secrets = append(secrets, w)
+		}
+	}
+	if len(secrets) > 0 {
+		u.Object["secrets"] = secrets
+	}
+
+	return u
+}
+
+// mergeStringMaps returns the union of both maps, values of override win
+func mergeStringMaps(base map[string]string, override map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// isUnchanged reports whether the live object already contains the desired labels, annotations and
+// content. Every field outside metadata and status is compared, as ServiceAccounts, Roles and
+// RoleBindings keep their content in secrets, rules, subjects and roleRef rather than in a spec.
+// ServiceAccount secrets only need to include the desired ones.
+func isUnchanged(desired *unstructured.Unstructured, live *unstructured.Unstructured) bool {
+	for k, v := range desired.GetLabels() {
+		if live.GetLabels()[k] != v {
+			return false
+		}
+	}
+
+	for k, v := range desired.GetAnnotations() {
+		if live.GetAnnotations()[k] != v {
+			return false
+		}
+	}
+
+	for field, value := range mergeSecrets(desired, live).Object {
+		if field == "metadata" || field == "status" {
+			continue
+		}
+		if !isSubset(value, live.Object[field]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSubset reports whether every value set in desired has the same value in live. Fields only
+// present in live, such as defaults filled in by the server, are ignored.
+func isSubset(desired interface{}, live interface{}) bool {
+	switch d := desired.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		l, _ := live.(map[string]interface{})
+		for k, v := range d {
+			if !isSubset(v, l[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, _ := live.([]interface{})
+		if len(d) != len(l) {
+			return false
+		}
+		for i := range d {
+			if !isSubset(d[i], l[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if live == nil {
+		return isZero(desired)
+	}
+
+	return reflect.DeepEqual(desired, live)
+}
+
+// isZero reports whether an unstructured scalar holds its zero value
+func isZero(v interface{}) bool {
+	switch s := v.(type) {
+	case string:
+		return s == ""
+	case bool:
+		return !s
+	case int64:
+		return s == 0
+	case float64:
+		return s == 0
+	}
+
+	return false
+}
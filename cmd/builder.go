@@ -0,0 +1,378 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Image builders supported by the build tasks
+const (
+	builderKaniko   = "kaniko"
+	builderBuildah  = "buildah"
+	builderBuildkit = "buildkit"
+)
+
+// Params and resources every build step template uses. The Dockerfile path is relative to the context.
+const (
+	buildDockerfile  = "${inputs.params.pathToDockerFile}"
+	buildContext     = "${inputs.params.pathToContext}"
+	buildRepository  = "${outputs.resources.image.url}"
+	buildDestination = buildRepository + ":${inputs.params." + imageTagParam + "}"
+)
+
+// imageTagParam is the param with the tag a run pushes its images to and runs its actions from.
+// Every PipelineRun has its own tag, so that concurrent runs never run each other's images.
+const imageTagParam = "imageTag"
+
+// latestTag is the default of imageTagParam, the tag TaskRuns of the workflow Task alone run, such as
+// the ones created by the transceiver. Build tasks promote the images they pushed to it.
+const latestTag = "latest"
+
+// Files the build steps surface the digest of the pushed image in
+const (
+	// imageOutputDir is where Tekton reads the OCI image index of the image output from, and
+	// records the digest of its only manifest in the status of the TaskRun
+	imageOutputDir = "/builder/home/image-outputs/image"
+	// pushedDigest is where builders that cannot write an image layout write what they pushed
+	pushedDigest = "/builder/home/image-digest"
+)
+
+// Volumes of the build steps
+const (
+	// buildahStorage is the volume buildah keeps images in between its build and push steps
+	buildahStorage = "buildah-storage"
+	// registryCredentialsVolume holds the docker config of --registry-secret
+	registryCredentialsVolume = "registry-credentials"
+)
+
+// Directories build steps read the docker config with the registry credentials from
+const (
+	kanikoDockerConfig = "/kaniko/.docker"
+	dockerConfigDir    = "/docker-config"
+	// tektonDockerConfig is where Tekton writes the docker credentials of the run's ServiceAccount
+	tektonDockerConfig = "/builder/home/.docker"
+)
+
+// kanikoImage is the debug variant of the kaniko executor, which has a shell to skip the build with
+const kanikoImage = "gcr.io/kaniko-project/executor:debug"
+
+var (
+	builder             string
+	insecureRegistries  []string
+	buildVerbosity      string
+	registrySecret      string
+	buildServiceAccount string
+)
+
+// buildTemplates render the steps of a build task and the volumes they need for each builder
+var buildTemplates = map[string]func(image Image) ([]pipeline.Step, []corev1.Volume){
+	builderKaniko:   kanikoTemplate,
+	builderBuildah:  buildahTemplate,
+	builderBuildkit: buildkitTemplate,
+}
+
+// buildTemplate returns the template of the builder selected with --builder
+func buildTemplate() func(image Image) ([]pipeline.Step, []corev1.Volume) {
+	template, ok := buildTemplates[builder]
+	if !ok {
+		Panic("Unsupported builder: %s. Expect %s\n", builder, strings.Join(builderNames(), ", "))
+	}
+
+	return template
+}
+
+// builderNames returns the supported builders in a stable order
+func builderNames() []string {
+	return []string{builderKaniko, builderBuildah, builderBuildkit}
+}
+
+// kanikoTemplate builds and pushes the image in a single kaniko executor step
+func kanikoTemplate(image Image) ([]pipeline.Step, []corev1.Volume) {
+	args := []string{
+		"--dockerfile=" + buildDockerfile,
+		"--destination=" + buildDestination,
+		"--context=" + buildContext,
+		"--verbosity=" + buildVerbosity,
+		"--oci-layout-path=" + imageOutputDir,
+	}
+	for _, r := range insecureRegistries {
+		args = append(args, "--insecure-registry="+r, "--skip-tls-verify-registry="+r)
+	}
+
+	options := buildOptions(image)
+	for _, a := range options.buildArgs() {
+		args = append(args, "--build-arg="+a)
+	}
+	if options.Target != "" {
+		args = append(args, "--target="+options.Target)
+	}
+	if options.Platform != "" {
+		args = append(args, "--customPlatform="+options.Platform)
+	}
+
+	env, mounts, volumes := registryCredentials(kanikoDockerConfig, "DOCKER_CONFIG", "")
+
+	cacheArgs, cacheMounts, cacheVolumes := kanikoCache(image)
+	args = append(args, cacheArgs...)
+	mounts = append(mounts, cacheMounts...)
+	volumes = append(volumes, cacheVolumes...)
+
+	return []pipeline.Step{{corev1.Container{
+		Name:         generateName(kindStep, "build-and-push-"+image.BuildTaskName),
+		Image:        kanikoImage,
+		Command:      []string{"/kaniko/executor"},
+		Args:         args,
+		Env:          env,
+		VolumeMounts: mounts,
+	}}}, volumes
+}
+
+// buildahTemplate builds the image and pushes it in two steps sharing the image storage. The vfs
+// driver and chroot isolation let buildah run without a privileged container, as the image's build
+// user. The storage is kept on an emptyDir volume, which that user can write.
+func buildahTemplate(image Image) ([]pipeline.Step, []corev1.Volume) {
+	uid := int64(1000)
+	storage := "/var/lib/containers"
+
+	env, mounts, volumes := registryCredentials(dockerConfigDir, "REGISTRY_AUTH_FILE", "config.json")
+	mounts = append(mounts, corev1.VolumeMount{
+		Name:      buildahStorage,
+		MountPath: storage,
+	})
+	env = append(env, corev1.EnvVar{
+		Name:  "BUILDAH_ISOLATION",
+		Value: "chroot",
+	})
+	tlsVerify := fmt.Sprintf("--tls-verify=%t", !insecureRegistry(imageRegistry(image)))
+
+	args := []string{
+		"--log-level=" + buildVerbosity,
+		"bud",
+		"--storage-driver=vfs",
+		"--root=" + storage + "/storage",
+		"--runroot=" + storage + "/run",
+		tlsVerify,
+		"--file=" + buildContext + "/" + buildDockerfile,
+		"--tag=" + buildDestination,
+	}
+	options := buildOptions(image)
+	for _, a := range options.buildArgs() {
+		args = append(args, "--build-arg="+a)
+	}
+	if options.Target != "" {
+		args = append(args, "--target="+options.Target)
+	}
+	if options.Platform != "" {
+		args = append(args, "--platform="+options.Platform)
+	}
+
+	build := corev1.Container{
+		Name:         generateName(kindStep, "build-"+image.BuildTaskName),
+		Image:        "quay.io/buildah/stable",
+		Command:      []string{"buildah"},
+		Args:         append(args, buildContext),
+		Env:          env,
+		VolumeMounts: mounts,
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:  &uid,
+			RunAsGroup: &uid,
+		},
+	}
+
+	push := corev1.Container{
+		Name:    generateName(kindStep, "push-"+image.BuildTaskName),
+		Image:   "quay.io/buildah/stable",
+		Command: []string{"buildah"},
+		Args: []string{
+			"--log-level=" + buildVerbosity,
+			"push",
+			"--storage-driver=vfs",
+			"--root=" + storage + "/storage",
+			"--runroot=" + storage + "/run",
+			tlsVerify,
+			"--digestfile=" + pushedDigest,
+			buildDestination,
+			"docker://" + buildDestination,
+		},
+		Env:          env,
+		VolumeMounts: mounts,
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:  &uid,
+			RunAsGroup: &uid,
+		},
+	}
+
+	volumes = append(volumes, corev1.Volume{
+		Name: buildahStorage,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+
+	return []pipeline.Step{{build}, {push}, {indexStep(image.BuildTaskName, "cat "+pushedDigest)}}, volumes
+}
+
+// buildkitTemplate builds and pushes the image with a daemonless, rootless buildkit. Nodes with
+// seccomp or AppArmor enforced need those profiles relaxed for the build pods.
+func buildkitTemplate(image Image) ([]pipeline.Step, []corev1.Volume) {
+	uid := int64(1000)
+
+	args := []string{
+		"build",
+		"--frontend=dockerfile.v0",
+		"--local=context=" + buildContext,
+		"--local=dockerfile=" + buildContext,
+		"--opt=filename=" + buildDockerfile,
+		fmt.Sprintf("--output=type=image,name=%s,push=true,registry.insecure=%t", buildDestination, insecureRegistry(imageRegistry(image))),
+		"--metadata-file=" + pushedDigest,
+	}
+	options := buildOptions(image)
+	for _, a := range options.buildArgs() {
+		args = append(args, "--opt=build-arg:"+a)
+	}
+	if options.Target != "" {
+		args = append(args, "--opt=target="+options.Target)
+	}
+	if options.Platform != "" {
+		args = append(args, "--opt=platform="+options.Platform)
+	}
+	// buildctl only has a debug switch, the other levels keep its default output
+	if buildVerbosity == "debug" || buildVerbosity == "trace" {
+		args = append([]string{"--debug"}, args...)
+	}
+
+	env, mounts, volumes := registryCredentials(dockerConfigDir, "DOCKER_CONFIG", "")
+	env = append(env, corev1.EnvVar{
+		Name:  "BUILDKITD_FLAGS",
+		Value: "--oci-worker-no-process-sandbox",
+	})
+
+	build := corev1.Container{
+		Name:         generateName(kindStep, "build-and-push-"+image.BuildTaskName),
+		Image:        "moby/buildkit:rootless",
+		Command:      []string{"buildctl-daemonless.sh"},
+		Args:         args,
+		Env:          env,
+		VolumeMounts: mounts,
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:  &uid,
+			RunAsGroup: &uid,
+		},
+	}
+
+	// the metadata file is indented JSON with the manifest digest under containerimage.digest
+	digest := `sed -n 's/.*"containerimage.digest": *"\(sha256:[0-9a-f]*\)".*/\1/p' ` + pushedDigest
+
+	return []pipeline.Step{{build}, {indexStep(image.BuildTaskName, digest)}}, volumes
+}
+
+// indexStep writes the digest printed by the command as a single manifest OCI image index in
+// imageOutputDir, which is how Tekton expects builders to report the digest of an image output
+func indexStep(name string, command string) corev1.Container {
+	return corev1.Container{
+		Name:    generateName(kindStep, "surface-digest-"+name),
+		Image:   "busybox",
+		Command: []string{"sh", "-c"},
+		Args:    []string{indexScript(command)},
+	}
+}
+
+// indexScript returns the shell commands writing the OCI image index of indexStep
+func indexScript(command string) string {
+	script := `mkdir -p %[1]s && digest=` + "`%[2]s`" + ` && ` +
+		`printf '{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","size":0,"digest":"%%s"}]}' "$digest" > %[1]s/index.json`
+
+	return fmt.Sprintf(script, imageOutputDir, command)
+}
+
+// latestStep tags the image pushed by the run as latestTag
+func latestStep(image Image) corev1.Container {
+	env, mounts, _ := registryCredentials(dockerConfigDir, "DOCKER_CONFIG", "")
+
+	crane := "crane"
+	if insecureRegistry(imageRegistry(image)) {
+		crane += " --insecure"
+	}
+
+	return corev1.Container{
+		Name:         generateName(kindStep, "tag-latest-"+image.BuildTaskName),
+		Image:        craneImage,
+		Command:      []string{"/busybox/sh", "-c"},
+		Args:         []string{crane + ` copy ` + buildDestination + ` ` + buildRepository + `:` + latestTag},
+		Env:          env,
+		VolumeMounts: mounts,
+	}
+}
+
+// registryCredentials returns the environment, mounts and volumes giving a build step the registry
+// credentials. The docker config of --registry-secret is mounted at dir, otherwise the one Tekton
+// writes for --build-service-account is used. The variable is set to the directory, or to file in
+// it for builders that expect a file.
+func registryCredentials(dir string, variable string, file string) ([]corev1.EnvVar, []corev1.VolumeMount, []corev1.Volume) {
+	if registrySecret == "" && buildServiceAccount == "" {
+		return nil, nil, nil
+	}
+
+	var mounts []corev1.VolumeMount
+	var volumes []corev1.Volume
+	if registrySecret != "" {
+		mounts = []corev1.VolumeMount{{
+			Name:      registryCredentialsVolume,
+			MountPath: dir,
+			ReadOnly:  true,
+		}}
+		volumes = []corev1.Volume{{
+			Name: registryCredentialsVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: registrySecret,
+					Items: []corev1.KeyToPath{{
+						Key:  corev1.DockerConfigJsonKey,
+						Path: "config.json",
+					}},
+				},
+			},
+		}}
+	} else {
+		dir = tektonDockerConfig
+	}
+
+	value := dir
+	if file != "" {
+		value = path.Join(dir, file)
+	}
+
+	return []corev1.EnvVar{{Name: variable, Value: value}}, mounts, volumes
+}
+
+// insecureRegistry reports whether the registry of an image reference was listed with --insecure-registry
+func insecureRegistry(ref string) bool {
+	host := strings.SplitN(ref, "/", 2)[0]
+	for _, r := range insecureRegistries {
+		if r == host {
+			return true
+		}
+	}
+
+	return false
+}
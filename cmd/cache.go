@@ -0,0 +1,166 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// allWorkflows enables the build cache for every workflow of the file
+const allWorkflows = "*"
+
+const (
+	// warmerImage pulls base images into the cache directory ahead of the build
+	warmerImage = "gcr.io/kaniko-project/warmer"
+	// baseImageCache is the volume of --cache-pvc and where kaniko looks up base images in it
+	baseImageCache    = "kaniko-cache"
+	baseImageCacheDir = "/cache"
+)
+
+var (
+	buildCache []string
+	cacheRepo  string
+	cacheTTL   string
+	cachePVC   string
+	warmImages []string
+)
+
+// addCacheFlags adds the flags configuring the kaniko layer cache of the build tasks
+func addCacheFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVarP(&buildCache, "build-cache", "", nil, "Workflows whose kaniko builds cache their layers, every workflow when no value is given")
+	cmd.Flags().Lookup("build-cache").NoOptDefVal = allWorkflows
+	cmd.Flags().StringVarP(&cacheRepo, "cache-repo", "", "", "Repository of the cached layers, defaults to the image repository with a /cache suffix")
+	cmd.Flags().StringVarP(&cacheTTL, "cache-ttl", "", "", "How long cached layers are used for, such as 168h. Defaults to kaniko's two weeks")
+	cmd.Flags().StringVarP(&cachePVC, "cache-pvc", "", "", "PersistentVolumeClaim kaniko reads cached base images from")
+	cmd.Flags().StringSliceVarP(&warmImages, "warm-image", "", nil, "Base image to pull into the --cache-pvc before building, can be repeated")
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		checkCacheFlags()
+	}
+}
+
+// checkCacheFlags panics when the cache flags are combined in a way that cannot be generated
+func checkCacheFlags() {
+	if len(buildCache) == 0 && (cacheRepo != "" || cacheTTL != "" || cachePVC != "" || len(warmImages) > 0) {
+		Panic("--cache-repo, --cache-ttl, --cache-pvc and --warm-image require --build-cache\n")
+	}
+	if len(buildCache) > 0 && builder != builderKaniko {
+		Panic("--build-cache is only supported by the %s builder\n", builderKaniko)
+	}
+	if len(warmImages) > 0 && cachePVC == "" {
+		Panic("--warm-image requires --cache-pvc\n")
+	}
+}
+
+// buildCacheEnabled reports whether the builds of a workflow use the layer cache
+func buildCacheEnabled(workflow string) bool {
+	for _, w := range buildCache {
+		if w == allWorkflows || w == workflow {
+			return true
+		}
+	}
+
+	return false
+}
+
+// kanikoCache returns the kaniko arguments, mounts and volumes caching the layers of the image. Images
+// of actions shared by workflows use the setting of the first workflow using them.
+func kanikoCache(image Image) ([]string, []corev1.VolumeMount, []corev1.Volume) {
+	if !buildCacheEnabled(image.Workflow) {
+		return nil, nil, nil
+	}
+
+	args := []string{"--cache=true"}
+	if cacheRepo != "" {
+		args = append(args, "--cache-repo="+cacheRepo)
+	}
+	if cacheTTL != "" {
+		args = append(args, "--cache-ttl="+cacheTTL)
+	}
+
+	if cachePVC == "" {
+		return args, nil, nil
+	}
+
+	args = append(args, "--cache-dir="+baseImageCacheDir)
+	mounts := []corev1.VolumeMount{{
+		Name:      baseImageCache,
+		MountPath: baseImageCacheDir,
+		ReadOnly:  true,
+	}}
+
+	return args, mounts, []corev1.Volume{baseImageCacheVolume()}
+}
+
+// createWarmTask returns the Task pulling the --warm-image base images into the --cache-pvc, nil when
+// the workflow has nothing to warm. It runs once per PipelineRun, before the build tasks, so that
+// the parallel builds only read the volume.
+func createWarmTask(workflow string) *pipeline.Task {
+	if !buildCacheEnabled(workflow) || len(warmImages) == 0 {
+		return nil
+	}
+
+	task := pipeline.Task{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Task",
+			APIVersion: "tekton.dev/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: generateName(kindTask, "warm-"+workflow),
+		},
+	}
+	setProvenance(&task.ObjectMeta, workflow, "")
+
+	args := []string{"--cache-dir=" + baseImageCacheDir}
+	for _, i := range warmImages {
+		args = append(args, "--image="+i)
+	}
+
+	// the warmer only pulls the images that are not in the cache yet
+	warm := corev1.Container{
+		Name:    generateName(kindStep, "warm-"+workflow),
+		Image:   warmerImage,
+		Command: []string{"/kaniko/warmer"},
+		Args:    args,
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      baseImageCache,
+			MountPath: baseImageCacheDir,
+		}},
+	}
+
+	task.Spec = pipeline.TaskSpec{
+		Steps:   []pipeline.Step{{warm}},
+		Volumes: []corev1.Volume{baseImageCacheVolume()},
+	}
+
+	return &task
+}
+
+// baseImageCacheVolume returns the volume of the --cache-pvc
+func baseImageCacheVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: baseImageCache,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: cachePVC,
+			},
+		},
+	}
+}
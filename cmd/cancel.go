@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/triggermesh/aktion/pkg/client"
+
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"knative.dev/pkg/apis"
+)
+
+var (
+	cancelPipelineRuns []string
+	cancelTaskRuns     []string
+)
+
+//NewCancelCmd creates cancel command
+func NewCancelCmd(kubeConfig *string, ns *string) *cobra.Command {
+	cancelCmd := &cobra.Command{
+		Use:   "cancel [workflow]",
+		Short: "Cancel the in-progress runs of a workflow, or the named runs",
+		Long: "Cancel the in-progress PipelineRuns and TaskRuns of a workflow, or only the\n" +
+			"runs named with --pipelinerun and --taskrun, in which case no workflow file is needed.",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			namespace = *ns
+
+			if len(cancelPipelineRuns) > 0 || len(cancelTaskRuns) > 0 {
+				clientSet := newClientSet(*kubeConfig)
+				for _, name := range cancelPipelineRuns {
+					cancelPipelineRun(clientSet, name)
+				}
+				for _, name := range cancelTaskRuns {
+					cancelTaskRun(clientSet, name)
+				}
+				return
+			}
+
+			workflow := selectWorkflow(ParseData(), args)
+			clientSet := newClientSet(*kubeConfig)
+
+			cancelled := 0
+			for _, r := range workflowRuns(clientSet, workflow) {
+				if r.Status != outcomePending && r.Status != outcomeRunning {
+					continue
+				}
+
+				if r.Kind == kindPipelineRun {
+					cancelPipelineRun(clientSet, r.Name)
+				} else {
+					cancelTaskRun(clientSet, r.Name)
+				}
+				cancelled++
+			}
+
+			if cancelled == 0 {
+				fmt.Printf("No runs of workflow %s in progress\n", workflow)
+			}
+		},
+	}
+
+	cancelCmd.Flags().StringSliceVarP(&cancelPipelineRuns, "pipelinerun", "", nil, "Names of the PipelineRuns to cancel")
+	cancelCmd.Flags().StringSliceVarP(&cancelTaskRuns, "taskrun", "", nil, "Names of the TaskRuns to cancel")
+
+	return cancelCmd
+}
+
+// cancelPipelineRun asks Tekton to stop a PipelineRun and the TaskRuns it created
+func cancelPipelineRun(clientSet client.ConfigSet, name string) {
+	pipelineRuns := clientSet.Pipeline.TektonV1alpha1().PipelineRuns(namespace)
+	completed := false
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pr, err := pipelineRuns.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if completed = runCompleted(pr.Status.GetCondition(apis.ConditionSucceeded)); completed {
+			return nil
+		}
+
+		pr.Spec.Status = pipeline.PipelineRunSpecStatusCancelled
+		_, err = pipelineRuns.Update(pr)
+		return err
+	})
+	if err != nil {
+		Panic("Unable to cancel pipeline run %s: %s\n", name, err)
+	}
+
+	if completed {
+		fmt.Printf("pipelinerun/%s already completed\n", name)
+		return
+	}
+	fmt.Printf("pipelinerun/%s cancelled\n", name)
+}
+
+// cancelTaskRun asks Tekton to stop a TaskRun
+func cancelTaskRun(clientSet client.ConfigSet, name string) {
+	taskRuns := clientSet.Pipeline.TektonV1alpha1().TaskRuns(namespace)
+	completed := false
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		tr, err := taskRuns.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if completed = runCompleted(tr.Status.GetCondition(apis.ConditionSucceeded)); completed {
+			return nil
+		}
+
+		tr.Spec.Status = pipeline.TaskRunSpecStatusCancelled
+		_, err = taskRuns.Update(tr)
+		return err
+	})
+	if err != nil {
+		Panic("Unable to cancel task run %s: %s\n", name, err)
+	}
+
+	if completed {
+		fmt.Printf("taskrun/%s already completed\n", name)
+		return
+	}
+	fmt.Printf("taskrun/%s cancelled\n", name)
+}
+
+// runCompleted reports whether the Succeeded condition of a run is final
+func runCompleted(cond *apis.Condition) bool {
+	return cond != nil && !cond.IsUnknown()
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+var (
+	configFile   string
+	aktionConfig Config
+)
+
+//Config is the aktion configuration file, which sets what workflow files cannot express
+type Config struct {
+	// Actions are keyed by the identifier of the action in the workflow file
+	Actions map[string]BuildOptions `json:"actions,omitempty"`
+	// Images are matched in order against the uses reference of actions, the first match applies
+	Images []ImageOverride `json:"images,omitempty"`
+	Runs   RunOptions      `json:"runs,omitempty"`
+}
+
+//ImageOverride changes where the image of the actions whose uses reference matches Match comes from.
+//In Match, * matches any characters, slashes included, and ? matches a single character.
+type ImageOverride struct {
+	Match string `json:"match"`
+	// Registry and Repository replace --registry and the generated repository of built images
+	Registry   string `json:"registry,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	// Image is a prebuilt image the actions run instead of building one
+	Image string `json:"image,omitempty"`
+	// Mirror replaces https://github.com for repository actions, and the registry of docker images.
+	// Use git@github.com: to clone repository actions over SSH.
+	Mirror string `json:"mirror,omitempty"`
+}
+
+//BuildOptions change how the image of an action is built
+type BuildOptions struct {
+	// Dockerfile is the path of the Dockerfile relative to the action's directory
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	BuildArgs  map[string]string `json:"buildArgs,omitempty"`
+	Target     string            `json:"target,omitempty"`
+	Platform   string            `json:"platform,omitempty"`
+}
+
+// loadConfig reads the configuration file set with --config
+func loadConfig() {
+	aktionConfig = Config{}
+	if configFile == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		Panic("Error opening config file: %s\n", err)
+	}
+
+	if err := yaml.Unmarshal(data, &aktionConfig); err != nil {
+		Panic("Error parsing config file %s: %s\n", configFile, err)
+	}
+
+	for _, o := range aktionConfig.Images {
+		if o.Match == "" {
+			Panic("Error parsing config file %s: image overrides need a match\n", configFile)
+		}
+	}
+}
+
+// imageOverride returns the first override matching the uses reference of an action
+func imageOverride(uses string) ImageOverride {
+	for _, o := range aktionConfig.Images {
+		if globMatch(o.Match, uses) {
+			return o
+		}
+	}
+
+	return ImageOverride{}
+}
+
+// globMatch reports whether the whole reference matches the glob pattern
+func globMatch(pattern string, reference string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+
+	return regexp.MustCompile("^" + expr + "$").MatchString(reference)
+}
+
+// mirrorRepository returns the URL of an org/repo repository in a git mirror. Mirrors ending with
+// a colon, such as git@github.com:, are scp-like SSH locations.
+func mirrorRepository(mirror string, repository string) string {
+	if strings.HasSuffix(mirror, ":") {
+		return mirror + repository
+	}
+
+	return strings.TrimSuffix(mirror, "/") + "/" + repository
+}
+
+// mirrorImage points a docker image reference at a mirror, keeping its repository and tag. Images
+// without a registry are Docker Hub images, whose official ones are in the library repository.
+func mirrorImage(reference string, mirror string) string {
+	if mirror == "" {
+		return reference
+	}
+
+	parts := strings.SplitN(reference, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		reference = parts[1]
+	} else if len(parts) == 1 {
+		reference = "library/" + reference
+	}
+
+	return strings.TrimSuffix(mirror, "/") + "/" + reference
+}
+
+// buildOptions returns the build options of an image. Images shared by actions are built with the
+// options of the first action that uses them.
+func buildOptions(image Image) BuildOptions {
+	return aktionConfig.Actions[image.Action]
+}
+
+// buildArgs returns the KEY=VALUE build args of the options, sorted by key
+func (o BuildOptions) buildArgs() []string {
+	keys := make([]string, 0, len(o.BuildArgs))
+	for k := range o.BuildArgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, k+"="+o.BuildArgs[k])
+	}
+
+	return args
+}
+
+// hash returns a short hash of the options, so that images built with other options are not reused
+func (o BuildOptions) hash() string {
+	data, err := json.Marshal(o)
+	if err != nil {
+		Panic("Error hashing build options: %s\n", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
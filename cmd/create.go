@@ -17,18 +17,18 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/actions/workflow-parser/model"
 	"github.com/spf13/cobra"
 
-	"github.com/triggermesh/aktion/pkg/client"
-
 	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 var (
@@ -38,6 +38,10 @@ var (
 	visitedActionDependency map[string]bool
 	pipelineResources       map[string]*Image
 	applyPipelineFlag       bool
+	nameMap                 bool
+	pruneFlag               bool
+	skipValidation          bool
+	dryRunMode              string
 )
 
 type ImageConst int
@@ -52,6 +56,11 @@ type Image struct {
 	Type                   ImageConst
 	Path                   string
 	BuildTaskName          string
+	Workflow               string
+	Action                 string
+	Registry               string
+	Repository             string
+	Mirror                 string
 	PipelineResourceSource pipeline.PipelineResource
 	PipelineResourceImage  pipeline.PipelineResource
 }
@@ -78,116 +87,128 @@ func NewCreateCmd(kubeConfig *string, ns *string, gitRepository *string) *cobra.
 		Use:   "create",
 		Short: "Convert the Github Action workflow into a Tekton Task list",
 		Run: func(cmd *cobra.Command, args []string) {
+			switch dryRunMode {
+			case dryRunNone:
+			case dryRunClient:
+				applyPipelineFlag = false
+			case dryRunServer:
+				if !applyPipelineFlag {
+					Panic("--dry-run=server requires --apply\n")
+				}
+			default:
+				Panic("Unsupported dry run mode: %s. Expect none, client or server\n", dryRunMode)
+			}
+
 			config := ParseData()
-			visitedActionDependency = make(map[string]bool)
-			pipelineResources = make(map[string]*Image)
+			resetGeneration()
 			namespace = *ns
 			repo = *gitRepository
 
+			objects := make(map[string][]runtime.Object)
+			failures := make([]string, 0)
 			for _, act := range config.Workflows {
 				tasks := extractTasks(act.Identifier, config)
-				primaryPipeline := createPipeline(tasks, act.Identifier, repo)
-				pipelineRun := createPipelineRun(act.Identifier, repo, act.Identifier)
-				pipelineRepo := createRepoPipelineResource(repo, act.Identifier, config)
-
-				if applyPipelineFlag {
-					applyPipeline(*kubeConfig, primaryPipeline, pipelineRun, createTask(tasks, repo), pipelineRepo)
-				} else {
-					fmt.Printf("%s", GenerateObjBreak(true))
-
-					for _, v := range pipelineResources {
-						fmt.Printf("%s", GenerateOutput(createPipelineResource(*v, true)))
-						fmt.Printf("%s", GenerateObjBreak(false))
-						fmt.Printf("%s", GenerateOutput(createPipelineResource(*v, false)))
-						fmt.Printf("%s", GenerateObjBreak(false))
-						fmt.Printf("%s", GenerateOutput(createBuildTask(*v)))
-						fmt.Printf("%s", GenerateObjBreak(false))
-					}
-
-					if repo != "" {
-						fmt.Printf("%s", GenerateOutput(*pipelineRepo))
-						fmt.Printf("%s", GenerateObjBreak(false))
-					}
-
-					fmt.Printf("%s", GenerateOutput(createTask(tasks, repo)))
-
-					fmt.Printf("%s", GenerateObjBreak(false))
-					fmt.Printf("%s", GenerateOutput(primaryPipeline))
-
-					if pipelinerun {
-						//fmt.Printf("---\n%s", GenerateOutput(taskRun))
-						fmt.Printf("%s", GenerateObjBreak(false))
-						fmt.Printf("%s", GenerateOutput(pipelineRun))
-					}
+				objects[act.Identifier] = generateObjects(tasks, act.Identifier, config)
+
+				if !skipValidation {
+					failures = append(failures, validateObjects(objects[act.Identifier])...)
 				}
 			}
 
-			fmt.Printf("%s", GenerateObjLastBreak())
+			if len(failures) > 0 {
+				Panic("Generated objects are invalid, use --skip-validation to ignore:\n  %s\n", strings.Join(failures, "\n  "))
+			}
+
+			workflows := make([]string, 0)
+			output := make([]runtime.Object, 0)
+			for _, act := range config.Workflows {
+				workflows = append(workflows, act.Identifier)
+				output = append(output, objects[act.Identifier]...)
+			}
+
+			if applyPipelineFlag {
+				applyPipeline(*kubeConfig, workflows, objects, pruneFlag)
+			} else {
+				PrintObjects(output)
+			}
+
+			if nameMap {
+				printNameMapping(os.Stderr)
+			}
 		},
 	}
 
-	createCmd.Flags().StringVarP(&revision, "revision", "", "master", "Upstream repository revision, branch, or tag")
-	createCmd.Flags().StringVarP(&registry, "registry", "r", "knative.registry.svc.cluster.local", "Default docker registry")
+	addGenerateFlags(createCmd)
 	createCmd.Flags().BoolVarP(&pipelinerun, "pipelinerun", "p", false, "Flag to create PipelineRun")
 	createCmd.Flags().BoolVarP(&applyPipelineFlag, "apply", "a", false, "Apply the generated Tekton pipeline to the user's kubernetes cluster")
+	createCmd.Flags().BoolVarP(&pruneFlag, "prune", "", false, "With --apply, delete objects previously generated for the workflow that are no longer part of it")
+	createCmd.Flags().StringVarP(&dryRunMode, "dry-run", "", dryRunNone, "With --apply, server submits every object as a server-side dry run and client only prints them (none|client|server)")
+	createCmd.Flags().BoolVarP(&skipValidation, "skip-validation", "", false, "Do not validate the generated Tekton objects before printing or applying them")
+	createCmd.Flags().BoolVarP(&nameMap, "name-map", "", false, "Print the mapping between workflow identifiers and generated object names to stderr")
 
 	return createCmd
 }
 
-// will need to add a lot more to the generation
-func applyPipeline(kubeConfig string, primaryPipeline pipeline.Pipeline, pipelineRun pipeline.PipelineRun, tasks pipeline.Task, pipelineRepo *pipeline.PipelineResource) {
-	// add if check for pipelinerun to build/inject the task
-	clientSet, err := client.NewClient(client.ConfigPath(kubeConfig))
-	if err != nil {
-		Panic("Error connecting to kubernetes cluster: %s\n", err)
-	}
+// addGenerateFlags adds the flags that change how workflows are converted to a command
+func addGenerateFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&revision, "revision", "", "master", "Upstream repository revision, branch, or tag")
+	cmd.Flags().StringVarP(&registry, "registry", "r", "knative.registry.svc.cluster.local", "Default docker registry")
+	cmd.Flags().StringVarP(&builder, "builder", "", builderKaniko, "Image builder of the build tasks ("+strings.Join(builderNames(), "|")+")")
+	cmd.Flags().StringSliceVarP(&insecureRegistries, "insecure-registry", "", nil, "Registry to push to and pull from without TLS verification, can be repeated")
+	cmd.Flags().StringVarP(&buildVerbosity, "build-verbosity", "", "info", "Log level of the image builder (panic|fatal|error|warn|info|debug|trace)")
+	cmd.Flags().StringVarP(&registrySecret, "registry-secret", "", "", "Secret of type kubernetes.io/dockerconfigjson with the registry credentials of the build tasks")
+	cmd.Flags().StringVarP(&buildServiceAccount, "build-service-account", "", "", "ServiceAccount of the build tasks, whose Tekton docker credentials are used to push")
+	cmd.Flags().BoolVarP(&rebuildImages, "rebuild", "", false, "Build every image, even when one built from the same context is already in the registry")
+	addCacheFlags(cmd)
+	cmd.Flags().StringVarP(&gitSecret, "git-secret", "", "", "Secret with the basic-auth or ssh-auth credentials runs clone private repositories with")
+	cmd.Flags().StringVarP(&gitServiceAccount, "git-service-account", "", "", "Existing ServiceAccount with git credentials that runs clone private repositories with")
+	cmd.Flags().BoolVarP(&rbacFlag, "rbac", "", false, "Also generate the ServiceAccount the runs of each workflow run as")
+	addRunFlags(cmd)
+}
 
-	// Apply resources
-	for _, v := range pipelineResources {
+// resetGeneration clears the conversion state before a workflow file is converted
+func resetGeneration() {
+	visitedActionDependency = make(map[string]bool)
+	pipelineResources = make(map[string]*Image)
+	resetNames()
+}
+
+// generateObjects converts a workflow into its Tekton objects, in the order they are printed and applied.
+// Only the images used by the workflow's actions are built, other workflows of the file build their own.
+func generateObjects(tasks Tasks, name string, config *model.Configuration) []runtime.Object {
+	objects := make([]runtime.Object, 0)
+
+	for _, v := range taskImages(tasks) {
 		srcResource := createPipelineResource(*v, true)
 		imgResource := createPipelineResource(*v, false)
 		buildTask := createBuildTask(*v)
-
-		_, err = clientSet.Pipeline.TektonV1alpha1().PipelineResources(namespace).Create(&srcResource)
-		if err != nil {
-			Panic("Unable to create source reference resource: %s\n", err)
-		}
-
-		_, err = clientSet.Pipeline.TektonV1alpha1().PipelineResources(namespace).Create(&imgResource)
-		if err != nil {
-			Panic("Unable to create source reference image: %s\n", err)
-		}
-
-		_, err = clientSet.Pipeline.TektonV1alpha1().Tasks(namespace).Create(&buildTask)
-		if err != nil {
-			Panic("Unable to create build task: %s\n", err)
-		}
+		objects = append(objects, &srcResource, &imgResource, &buildTask)
 	}
 
-	// Apply global git repo
-	if pipelineRepo != nil {
-		_, err = clientSet.Pipeline.TektonV1alpha1().PipelineResources(namespace).Create(pipelineRepo)
-		if err != nil {
-			Panic("Unable to create global repo resource: %s\n", err)
-		}
+	if pipelineRepo := createRepoPipelineResource(repo, name, config); pipelineRepo != nil {
+		objects = append(objects, pipelineRepo)
 	}
 
-	_, err = clientSet.Pipeline.TektonV1alpha1().Tasks(namespace).Create(&tasks)
-	if err != nil {
-		Panic("Unable to create tasks: %s\n", err)
+	if rbacFlag {
+		objects = append(objects, createRunnerServiceAccount(name))
+	} else if account := createGitServiceAccount(name); account != nil {
+		objects = append(objects, account)
 	}
 
-	_, err = clientSet.Pipeline.TektonV1alpha1().Pipelines(namespace).Create(&primaryPipeline)
-	if err != nil {
-		Panic("Unable to create primary pipeline: %s\n", err)
+	if warmTask := createWarmTask(name); warmTask != nil && len(taskImages(tasks)) > 0 {
+		objects = append(objects, warmTask)
 	}
 
+	task := createTask(tasks, repo)
+	primaryPipeline := createPipeline(tasks, name, repo)
+	objects = append(objects, &task, &primaryPipeline)
+
 	if pipelinerun {
-		_, err = clientSet.Pipeline.TektonV1alpha1().PipelineRuns(namespace).Create(&pipelineRun)
-		if err != nil {
-			Panic("Unable to create pipeline run: %s\n", err)
-		}
+		pipelineRun := createPipelineRun(tasks, name, repo, name)
+		objects = append(objects, &pipelineRun)
 	}
+
+	return objects
 }
 
 func extractTasks(name string, config *model.Configuration) Tasks {
@@ -199,20 +220,20 @@ func extractTasks(name string, config *model.Configuration) Tasks {
 
 	extractedTasks := make([]Task, 0)
 	for _, a := range workflow.Resolves {
-		extractedTasks = append(extractedTasks, extractActions(config.GetAction(a), config)...)
+		extractedTasks = append(extractedTasks, extractActions(name, config.GetAction(a), config)...)
 	}
 	tasks.Task = extractedTasks
 
 	return tasks
 }
 
-func extractActions(action *model.Action, config *model.Configuration) []Task {
+func extractActions(workflow string, action *model.Action, config *model.Configuration) []Task {
 	tasks := make([]Task, 0)
 
 	if len(action.Needs) > 0 {
 		for _, a := range action.Needs {
 			if !visitedActionDependency[config.GetAction(a).Identifier] {
-				tasks = append(tasks, extractActions(config.GetAction(a), config)...)
+				tasks = append(tasks, extractActions(workflow, config.GetAction(a), config)...)
 			}
 		}
 	}
@@ -225,21 +246,32 @@ func extractActions(action *model.Action, config *model.Configuration) []Task {
 		Identifier: action.Identifier,
 	}
 
-	if strings.HasPrefix(action.Uses.String(), "docker://") {
+	override := imageOverride(action.Uses.String())
+	if override.Image != "" {
+		task.Image = &Image{
+			Type: DOCKER,
+			Path: override.Image,
+		}
+	} else if strings.HasPrefix(action.Uses.String(), "docker://") {
 		task.Image = &Image{
 			Type: DOCKER,
-			Path: strings.TrimPrefix(action.Uses.String(), "docker://"),
+			Path: mirrorImage(strings.TrimPrefix(action.Uses.String(), "docker://"), override.Mirror),
 		}
 	} else if strings.HasPrefix(action.Uses.String(), "./") {
 		if len(repo) == 0 {
 			Panic("The git flag must be specified to use the action: %s\n", action.Identifier)
 		} else if pipelineResources[convertUsesName(action.Uses.String())] != nil {
-			task.Image = pipelineResources[convertUsesName(action.Uses.String())]
+			task.Image = sharedImage(action, strings.TrimPrefix(action.Uses.String(), "./"))
 		} else {
 			task.Image = &Image{
 				Type:          LOCAL,
 				Path:          strings.TrimPrefix(action.Uses.String(), "./"),
 				BuildTaskName: convertUsesName(action.Uses.String()),
+				Workflow:      workflow,
+				Action:        action.Identifier,
+				Registry:      override.Registry,
+				Repository:    override.Repository,
+				Mirror:        override.Mirror,
 			}
 
 			task.Image.PipelineResourceSource = createPipelineResource(*task.Image, true)
@@ -248,12 +280,17 @@ func extractActions(action *model.Action, config *model.Configuration) []Task {
 		}
 	} else if strings.Contains(action.Uses.String(), "@") {
 		if pipelineResources[convertUsesName(action.Uses.String())] != nil {
-			task.Image = pipelineResources[convertUsesName(action.Uses.String())]
+			task.Image = sharedImage(action, "github.com/"+action.Uses.String())
 		} else {
 			task.Image = &Image{
 				Type:          GIT,
 				Path:          "github.com/" + action.Uses.String(),
 				BuildTaskName: convertUsesName(action.Uses.String()),
+				Workflow:      workflow,
+				Action:        action.Identifier,
+				Registry:      override.Registry,
+				Repository:    override.Repository,
+				Mirror:        override.Mirror,
 			}
 
 			task.Image.PipelineResourceSource = createPipelineResource(*task.Image, true)
@@ -306,6 +343,18 @@ func extractActions(action *model.Action, config *model.Configuration) []Task {
 	return append(tasks, task)
 }
 
+// sharedImage returns the already registered Image for the action's uses entry, making sure
+// that it was registered for the same path and not for a different uses entry with the same name
+func sharedImage(action *model.Action, path string) *Image {
+	image := pipelineResources[convertUsesName(action.Uses.String())]
+	if image.Path != path {
+		Panic("Name collision: uses entries %q and %q of action %s generate the same name %q\n",
+			image.Path, path, action.Identifier, image.BuildTaskName)
+	}
+
+	return image
+}
+
 // createPipeline Generates the pipeline and associated tasks
 func createPipeline(tasks Tasks, name string, repo string) pipeline.Pipeline {
 	line := pipeline.Pipeline{
@@ -314,18 +363,31 @@ func createPipeline(tasks Tasks, name string, repo string) pipeline.Pipeline {
 			APIVersion: "tekton.dev/v1alpha1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:              convertName(name + "-pipeline"),
+			Name:              generateName(kindPipeline, name+"-pipeline"),
 			CreationTimestamp: metav1.Time{time.Now()},
 		},
 	}
 
+	setProvenance(&line.ObjectMeta, name, "")
+
 	// TODO: Do we want to apply the custom resource definition for defined repos?
 
 	specResources := make([]pipeline.PipelineDeclaredResource, 0)
 	specTasks := make([]pipeline.Task, 0)
 	specPipelineTask := make([]pipeline.PipelineTask, 0)
 
-	for _, v := range pipelineResources {
+	// the base image cache is warmed once, before the builds read it
+	warmTask := createWarmTask(name)
+	if warmTask != nil && len(taskImages(tasks)) > 0 {
+		specPipelineTask = append(specPipelineTask, pipeline.PipelineTask{
+			Name: warmTask.Name,
+			TaskRef: pipeline.TaskRef{
+				Name: warmTask.Name,
+			},
+		})
+	}
+
+	for _, v := range taskImages(tasks) {
 		srcResource := pipeline.PipelineDeclaredResource{
 			Name: v.PipelineResourceSource.ObjectMeta.Name,
 			Type: v.PipelineResourceSource.Spec.Type,
@@ -362,7 +424,21 @@ func createPipeline(tasks Tasks, name string, repo string) pipeline.Pipeline {
 					Type: pipeline.ParamTypeString,
 					StringVal: "/workspace/workspace/" + extractRepoPath(v.Path, v.Type),
 				},
-			}},
+			}, imageTagPipelineParam()},
+		}
+
+		if dockerfile := buildOptions(*v).Dockerfile; dockerfile != "" {
+			pipelineBuildTask.Params = append(pipelineBuildTask.Params, pipeline.Param{
+				Name: "pathToDockerFile",
+				Value: pipeline.ArrayOrString{
+					Type:      pipeline.ParamTypeString,
+					StringVal: dockerfile,
+				},
+			})
+		}
+
+		if warmTask != nil {
+			pipelineBuildTask.RunAfter = []string{warmTask.Name}
 		}
 
 		specPipelineTask = append(specPipelineTask, pipelineBuildTask)
@@ -376,20 +452,44 @@ func createPipeline(tasks Tasks, name string, repo string) pipeline.Pipeline {
 		},
 	}
 
+	inputs := make([]pipeline.PipelineTaskInputResource, 0)
 	if repo != "" {
 		specResources = append(specResources, pipeline.PipelineDeclaredResource{
 			Name: convertName(name),
 			Type: pipeline.PipelineResourceTypeGit,
 		})
 
+		inputs = append(inputs, pipeline.PipelineTaskInputResource{
+			Name:     convertName(name),
+			Resource: convertName(name),
+		})
+	}
+
+	// the images come from their build tasks, so the task only starts once they have been pushed
+	for _, image := range taskImages(tasks) {
+		buildTaskName := generateName(kindTask, "build-"+image.BuildTaskName)
+		inputs = append(inputs, pipeline.PipelineTaskInputResource{
+			Name:     image.PipelineResourceImage.Name,
+			Resource: image.PipelineResourceImage.Name,
+			From:     []string{buildTaskName},
+		})
+		primaryPipelineTask.RunAfter = append(primaryPipelineTask.RunAfter, buildTaskName)
+	}
+
+	if len(inputs) > 0 {
 		primaryPipelineTask.Resources = &pipeline.PipelineTaskResources{
-			Inputs: []pipeline.PipelineTaskInputResource{{
-				Name:     convertName(name),
-				Resource: convertName(name),
-			}},
+			Inputs: inputs,
 		}
 	}
 
+	if len(taskImages(tasks)) > 0 {
+		line.Spec.Params = []pipeline.ParamSpec{{
+			Name: imageTagParam,
+			Type: pipeline.ParamTypeString,
+		}}
+		primaryPipelineTask.Params = []pipeline.Param{imageTagPipelineParam()}
+	}
+
 	specPipelineTask = append(specPipelineTask, primaryPipelineTask)
 	line.Spec.Resources = specResources
 	line.Spec.Tasks = specPipelineTask
@@ -397,11 +497,12 @@ func createPipeline(tasks Tasks, name string, repo string) pipeline.Pipeline {
 	return line
 }
 
-func createPipelineRun(name string, repo string, workflowName string) pipeline.PipelineRun {
+// createPipelineRun binds the resources of the workflow's repository and of the images its actions use
+func createPipelineRun(tasks Tasks, name string, repo string, workflowName string) pipeline.PipelineRun {
 	// setup the resource run bindings
 	resourceBindings := make([]pipeline.PipelineResourceBinding, 0)
 
-	for _, v := range pipelineResources {
+	for _, v := range taskImages(tasks) {
 		resourceBindings = append(resourceBindings, pipeline.PipelineResourceBinding{
 			Name: v.PipelineResourceImage.Name,
 			ResourceRef: pipeline.PipelineResourceRef{
@@ -429,21 +530,40 @@ func createPipelineRun(name string, repo string, workflowName string) pipeline.P
 	pipelineRun := pipeline.PipelineRun{
 		Spec: pipeline.PipelineRunSpec{
 			PipelineRef: pipeline.PipelineRef{
-				Name: convertName(name + "-pipeline"),
+				Name: generateName(kindPipeline, name+"-pipeline"),
 			},
 			Resources: resourceBindings,
 		},
 	}
 
+	if len(taskImages(tasks)) > 0 {
+		pipelineRun.Spec.Params = []pipeline.Param{{
+			Name: imageTagParam,
+			Value: pipeline.ArrayOrString{
+				Type:      pipeline.ParamTypeString,
+				StringVal: runImageTag(),
+			},
+		}}
+	}
+
+	options := runOptions()
+	pipelineRun.Spec.ServiceAccount = runServiceAccount(workflowName)
+	if accounts := taskServiceAccounts(tasks, options); len(accounts) > 0 {
+		pipelineRun.Spec.ServiceAccounts = accounts
+	}
+	pipelineRun.Spec.PodTemplate = options.PodTemplate
+
 	pipelineRun.TypeMeta = metav1.TypeMeta{
 		Kind:       "PipelineRun",
 		APIVersion: "tekton.dev/v1alpha1",
 	}
 
+	// every run is a new object, named by the server
 	pipelineRun.ObjectMeta = metav1.ObjectMeta{
-		Name:              convertName(name + "-pipeline-run"),
+		GenerateName:      generateNamePrefix(generateName(kindPipelineRun, name+"-pipeline-run")),
 		CreationTimestamp: metav1.Time{time.Now()},
 	}
+	setProvenance(&pipelineRun.ObjectMeta, workflowName, "")
 
 	return pipelineRun
 }
@@ -456,9 +576,10 @@ func createTask(tasks Tasks, repo string) pipeline.Task {
 			APIVersion: "tekton.dev/v1alpha1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: convertName(tasks.Identifier),
+			Name: generateName(kindTask, tasks.Identifier),
 		},
 	}
+	setProvenance(&task.ObjectMeta, tasks.Identifier, "")
 
 	var taskSpec pipeline.TaskSpec
 	steps := make([]pipeline.Step, 0)
@@ -467,14 +588,36 @@ func createTask(tasks Tasks, repo string) pipeline.Task {
 	repoResource.Name = convertName(tasks.Identifier)
 	repoResource.Type = pipeline.PipelineResourceTypeGit
 
+	resources := make([]pipeline.TaskResource, 0)
 	if repo != "" {
+		resources = append(resources, repoResource)
+	}
+
+	for _, image := range taskImages(tasks) {
+		resources = append(resources, pipeline.TaskResource{
+			Name: image.PipelineResourceImage.Name,
+			Type: pipeline.PipelineResourceTypeImage,
+		})
+	}
+
+	if len(resources) > 0 {
 		taskSpec.Inputs = &pipeline.Inputs{
-			Resources: []pipeline.TaskResource{
-				repoResource,
-			},
+			Resources: resources,
 		}
 	}
 
+	// TaskRuns of the Task alone run the images the last PipelineRun pushed
+	if len(taskImages(tasks)) > 0 {
+		taskSpec.Inputs.Params = []pipeline.ParamSpec{{
+			Name: imageTagParam,
+			Type: pipeline.ParamTypeString,
+			Default: &pipeline.ArrayOrString{
+				Type:      pipeline.ParamTypeString,
+				StringVal: latestTag,
+			},
+		}}
+	}
+
 	for _, t := range tasks.Task {
 		steps = append(steps, createContainer(t))
 	}
@@ -484,6 +627,23 @@ func createTask(tasks Tasks, repo string) pipeline.Task {
 	return task
 }
 
+// taskImages returns the images built for the actions of tasks, in the order they are first used
+func taskImages(tasks Tasks) []*Image {
+	images := make([]*Image, 0)
+	seen := make(map[*Image]bool)
+
+	for _, t := range tasks.Task {
+		if t.Image.Type == DOCKER || seen[t.Image] {
+			continue
+		}
+
+		seen[t.Image] = true
+		images = append(images, t.Image)
+	}
+
+	return images
+}
+
 // Given the github-action repo designation of org/repo/path..., return just the org/repo portion
 func extractRepoPrefix(repo string) string {
 	basedir := strings.Split(repo, "@")[0]
@@ -550,9 +710,10 @@ func createPipelineResource(image Image, resourceType bool) pipeline.PipelineRes
 			APIVersion: "tekton.dev/v1alpha1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: convertName(resourceName),
+			Name: generateName(kindPipelineResource, resourceName),
 		},
 	}
+	setProvenance(&resource.ObjectMeta, image.Workflow, image.Action)
 
 	resourceParams := make([]pipeline.ResourceParam, 0)
 
@@ -574,6 +735,9 @@ func createPipelineResource(image Image, resourceType bool) pipeline.PipelineRes
 		} else if image.Type == GIT {
 			// TODO: If repo is passed as an argument, do we use that to override this?
 			url = "https://" + extractRepoSuffix(image.Path)
+			if image.Mirror != "" {
+				url = mirrorRepository(image.Mirror, strings.TrimPrefix(extractRepoSuffix(image.Path), "github.com/"))
+			}
 			revision = extractRepoRevision(image.Path) // This is for the 3rd party repo being accessed
 		}
 
@@ -597,7 +761,7 @@ func createPipelineResource(image Image, resourceType bool) pipeline.PipelineRes
 		resourceParams = append(resourceParams,
 			pipeline.ResourceParam{
 				Name:  "url",
-				Value: registry + "/" + image.BuildTaskName + "-image",
+				Value: imageRegistry(image) + "/" + imageRepository(image),
 			})
 
 		resource.Spec = pipeline.PipelineResourceSpec{
@@ -609,6 +773,24 @@ func createPipelineResource(image Image, resourceType bool) pipeline.PipelineRes
 	return resource
 }
 
+// imageRegistry returns the registry a built image is pushed to
+func imageRegistry(image Image) string {
+	if image.Registry != "" {
+		return image.Registry
+	}
+
+	return registry
+}
+
+// imageRepository returns the repository of a built image in its registry
+func imageRepository(image Image) string {
+	if image.Repository != "" {
+		return image.Repository
+	}
+
+	return image.BuildTaskName + "-image"
+}
+
 // createRepoPipelineResource - Ensure that we create a PipelineResource object for the CLI specified repo
 func createRepoPipelineResource(repo string, workflow string, config *model.Configuration) *pipeline.PipelineResource {
 	var url string
@@ -625,9 +807,10 @@ func createRepoPipelineResource(repo string, workflow string, config *model.Conf
 			APIVersion: "tekton.dev/v1alpha1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: convertName(workflow),
+			Name: generateName(kindPipelineResource, workflow),
 		},
 	}
+	setProvenance(&resource.ObjectMeta, workflow, "")
 
 	hasVersion := strings.IndexAny(repo, "@")
 	if hasVersion == -1 {
@@ -659,7 +842,7 @@ func createRepoPipelineResource(repo string, workflow string, config *model.Conf
 	return &resource
 }
 
-// createBuildTask create a task to clone a git repo and use Kaniko to build the docker image
+// createBuildTask create a task to clone a git repo and build the docker image with the selected builder
 func createBuildTask(image Image) pipeline.Task {
 	task := pipeline.Task{
 		TypeMeta: metav1.TypeMeta{
@@ -667,9 +850,10 @@ func createBuildTask(image Image) pipeline.Task {
 			APIVersion: "tekton.dev/v1alpha1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "build-" + convertName(image.BuildTaskName),
+			Name: generateName(kindTask, "build-"+image.BuildTaskName),
 		},
 	}
+	setProvenance(&task.ObjectMeta, image.Workflow, image.Action)
 
 	// CAB: The pathToDocker and pathToContext get set in the pipeline when calling taskRef
 	var inputResource pipeline.TaskResource
@@ -679,19 +863,18 @@ func createBuildTask(image Image) pipeline.Task {
 	var outputResource pipeline.TaskResource
 	outputResource.Name = "image"
 	outputResource.Type = pipeline.PipelineResourceTypeImage
+	outputResource.OutputImageDir = imageOutputDir
 
-	buildContainer := corev1.Container{
-		Name:    "build-and-push-" + convertName(image.BuildTaskName),
-		Image:   "gcr.io/kaniko-project/executor",
-		Command: []string{"/kaniko/executor"},
-		Args: []string{
-			"--dockerfile=${inputs.params.pathToDockerFile}",
-			"--destination=${outputs.resources.image.url}",
-			"--context=${inputs.params.pathToContext}",
-			"--insecure",
-			"--insecure-registry",
-			"--verbosity=debug", // DEBUG MODE
-		},
+	steps, volumes := buildTemplate()(image)
+	if !rebuildImages {
+		steps, volumes = reuseSteps(image, steps, volumes)
+	}
+	steps = append(steps, pipeline.Step{latestStep(image)})
+	_, _, credentials := registryCredentials(dockerConfigDir, "DOCKER_CONFIG", "")
+	for _, c := range credentials {
+		if !hasVolume(volumes, c.Name) {
+			volumes = append(volumes, c)
+		}
 	}
 
 	task.Spec = pipeline.TaskSpec{
@@ -710,6 +893,15 @@ func createBuildTask(image Image) pipeline.Task {
 				},
 				{
 					Name: "pathToContext",
+					Type: pipeline.ParamTypeString,
+				},
+				{
+					Name: imageTagParam,
+					Type: pipeline.ParamTypeString,
+					Default: &pipeline.ArrayOrString{
+						Type:      pipeline.ParamTypeString,
+						StringVal: latestTag,
+					},
 				},
 			},
 		},
@@ -718,9 +910,8 @@ func createBuildTask(image Image) pipeline.Task {
 				outputResource,
 			},
 		},
-		Steps: []pipeline.Step{{
-			buildContainer,
-		}},
+		Steps:   steps,
+		Volumes: volumes,
 	}
 
 	return task
@@ -728,13 +919,15 @@ func createBuildTask(image Image) pipeline.Task {
 
 func createContainer(task Task) pipeline.Step {
 	// Need to be a little more intelligent with the Image.
+	// Built images come from the image input resource passed on by their build task, with the tag
+	// of the run, which is never moved once pushed.
 	path := task.Image.Path
 	if task.Image.Type != DOCKER {
-		path = registry + "/" + task.Image.PipelineResourceImage.Name
+		path = "${inputs.resources." + task.Image.PipelineResourceImage.Name + ".url}:${inputs.params." + imageTagParam + "}"
 	}
 
 	return pipeline.Step{corev1.Container{
-		Name:    convertName(task.Identifier),
+		Name:    generateName(kindAction, task.Identifier),
 		Image:   path,
 		Command: task.Cmd,
 		Args:    task.Args,
@@ -743,18 +936,23 @@ func createContainer(task Task) pipeline.Step {
 	}}
 }
 
-func convertName(name string) string {
-	n := strings.Replace(name, " ", "-", -1)
-	return strings.ToLower(n)
+// imageTagPipelineParam passes the image tag of the PipelineRun on to a task of the Pipeline
+func imageTagPipelineParam() pipeline.Param {
+	return pipeline.Param{
+		Name: imageTagParam,
+		Value: pipeline.ArrayOrString{
+			Type:      pipeline.ParamTypeString,
+			StringVal: "${params." + imageTagParam + "}",
+		},
+	}
+}
+
+// runImageTag returns a new image tag for a PipelineRun
+func runImageTag() string {
+	return "run-" + strconv.FormatInt(time.Now().UnixNano(), 36)
 }
 
 // Convert the workflow Uses entry to a common name that can be referenced
 func convertUsesName(name string) string {
-	n := strings.Split(name, "@")[0]
-	n = strings.Replace(n, "/", "-", -1)
-	n = strings.Replace(n, ".", "-", -1)
-	n = strings.Replace(n, "--", "-", -1)
-	n = strings.TrimPrefix(n, "-")
-
-	return strings.ToLower(n)
+	return convertName(strings.Split(name, "@")[0])
 }
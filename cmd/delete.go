@@ -0,0 +1,214 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+var (
+	// tektonGroupVersion is the API version of every generated Tekton object
+	tektonGroupVersion = schema.GroupVersion{Group: "tekton.dev", Version: "v1alpha1"}
+
+	// prunedResources are the generated definitions removed by --prune when they are no longer generated
+	prunedResources = []schema.GroupVersionResource{
+		tektonGroupVersion.WithResource("pipelines"),
+		tektonGroupVersion.WithResource("tasks"),
+		tektonGroupVersion.WithResource("pipelineresources"),
+		corev1.SchemeGroupVersion.WithResource("serviceaccounts"),
+		rbacv1.SchemeGroupVersion.WithResource("roles"),
+		rbacv1.SchemeGroupVersion.WithResource("rolebindings"),
+	}
+
+	// workflowResources are all the resources deleted with a workflow, including its runs
+	workflowResources = append([]schema.GroupVersionResource{
+		tektonGroupVersion.WithResource("pipelineruns"),
+		tektonGroupVersion.WithResource("taskruns"),
+	}, prunedResources...)
+)
+
+//NewDeleteCmd creates delete command
+func NewDeleteCmd(kubeConfig *string, ns *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete [workflow...]",
+		Short: "Delete the Tekton objects created for the workflows of a Github Action workflow file",
+		Long: "Delete the Tekton objects created for the workflows of a Github Action workflow file.\n" +
+			"All workflows of the file are deleted unless workflow identifiers are given. The transceiver\n" +
+			"objects created by launch are kept.",
+		Run: func(cmd *cobra.Command, args []string) {
+			config := ParseData()
+			namespace = *ns
+
+			workflows := args
+			if len(workflows) == 0 {
+				for _, w := range config.Workflows {
+					workflows = append(workflows, w.Identifier)
+				}
+			}
+
+			a := newApplier(*kubeConfig)
+
+			for _, w := range workflows {
+				if config.GetWorkflow(w) == nil {
+					Panic("Workflow %q not found in %s\n", w, filename)
+				}
+
+				if err := a.deleteWorkflow(w, workflowResources, nil); err != nil {
+					Panic("Unable to delete workflow %s: %s\n", w, err)
+				}
+			}
+		},
+	}
+}
+
+// deleteWorkflow deletes the objects labelled for the workflow in the given resources,
+// except the ones in keep, and reports every deleted object. The build Tasks and PipelineResources
+// of actions shared with other workflows are labelled for the first of them only, so the ones still
+// used by the Pipeline of another workflow are relabelled for that workflow instead of deleted.
+func (a *applier) deleteWorkflow(workflow string, resources []schema.GroupVersionResource, keep map[string]bool) error {
+	policy := metav1.DeletePropagationBackground
+
+	shared, err := a.sharedObjects(workflow)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		res := a.client.Resource(resource).Namespace(a.namespace)
+
+		// the transceiver objects of launch share the workflow label, but are not removed with it
+		selector := workflowSelector(workflow) + ",!" + labelComponent
+		list, err := res.List(metav1.ListOptions{LabelSelector: selector})
+		if errors.IsForbidden(err) && resource.GroupVersion() != tektonGroupVersion {
+			// only --rbac and --git-secret generate other objects, users without them may not list them
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		for _, item := range list.Items {
+			if keep[resourceKey(resource.Resource, item.GetName())] {
+				continue
+			}
+
+			if owner := shared[resourceKey(resource.Resource, item.GetName())]; owner != "" {
+				if err := a.relabel(res, &item, owner); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if a.dryRun {
+				err = a.rest.Delete().
+					AbsPath(append(resourcePath(resource, a.namespace), item.GetName())...).
+					Param("dryRun", dryRunAll).
+					Param("propagationPolicy", string(policy)).
+					Do().
+					Error()
+			} else {
+				err = res.Delete(item.GetName(), &metav1.DeleteOptions{PropagationPolicy: &policy})
+			}
+			if err != nil {
+				return err
+			}
+			a.record(res, objectRef(&item), item.GetName(), item.DeepCopy(), true)
+
+			fmt.Printf("%s deleted%s\n", objectRef(&item), a.suffix())
+		}
+	}
+
+	return nil
+}
+
+// sharedObjects returns the Tasks and PipelineResources the Pipelines of other workflows refer to,
+// keyed by resourceKey, with the workflow label of one of those Pipelines
+func (a *applier) sharedObjects(workflow string) (map[string]string, error) {
+	shared := make(map[string]string)
+
+	res := a.client.Resource(tektonGroupVersion.WithResource("pipelines")).Namespace(a.namespace)
+	list, err := res.List(metav1.ListOptions{LabelSelector: labelManagedBy + "=" + managedByAktion})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range list.Items {
+		owner := item.GetLabels()[labelWorkflow]
+		if owner == "" || owner == convertName(workflow) {
+			continue
+		}
+
+		var line pipeline.Pipeline
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &line); err != nil {
+			return nil, fmt.Errorf("reading %s: %s", objectRef(&item), err)
+		}
+
+		for _, t := range line.Spec.Tasks {
+			shared[resourceKey("tasks", t.TaskRef.Name)] = owner
+		}
+		for _, r := range line.Spec.Resources {
+			shared[resourceKey("pipelineresources", r.Name)] = owner
+		}
+	}
+
+	return shared, nil
+}
+
+// relabel moves a shared object to the workflow label of a workflow still using it
+func (a *applier) relabel(res dynamic.ResourceInterface, item *unstructured.Unstructured, owner string) error {
+	if a.dryRun {
+		fmt.Printf("%s kept for workflow %s%s\n", objectRef(item), owner, a.suffix())
+		return nil
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live, err := res.Get(item.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		labels := live.GetLabels()
+		labels[labelWorkflow] = owner
+		live.SetLabels(labels)
+		_, err = res.Update(live)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	a.record(res, objectRef(item), item.GetName(), item.DeepCopy(), false)
+
+	fmt.Printf("%s kept for workflow %s\n", objectRef(item), owner)
+	return nil
+}
+
+// resourceKey identifies an object by its resource and name
+func resourceKey(resource string, name string) string {
+	return resource + "/" + name
+}
@@ -0,0 +1,349 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Changes reported by diff
+const (
+	changeAdded     = "added"
+	changeModified  = "modified"
+	changeUnchanged = "unchanged"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+var diffSummary bool
+
+//ObjectChange summarises the difference between a generated object and its live version
+type ObjectChange struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Change string `json:"change"`
+}
+
+// diffLine is a line of a line-based diff, op is one of ' ', '-' or '+'
+type diffLine struct {
+	op   byte
+	text string
+}
+
+//NewDiffCmd creates diff command
+func NewDiffCmd(kubeConfig *string, ns *string, gitRepository *string) *cobra.Command {
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show the differences between the generated Tekton objects and the ones in the cluster",
+		Long: "Show the differences between the generated Tekton objects and the ones in the cluster.\n" +
+			"Exits with status 1 when the cluster is not up to date.",
+		Run: func(cmd *cobra.Command, args []string) {
+			config := ParseData()
+			resetGeneration()
+			namespace = *ns
+			repo = *gitRepository
+
+			a := newApplier(*kubeConfig)
+
+			changes := make([]ObjectChange, 0)
+			differs := false
+
+			for _, act := range config.Workflows {
+				tasks := extractTasks(act.Identifier, config)
+
+				for _, obj := range generateObjects(tasks, act.Identifier, config) {
+					desired, err := toUnstructured(obj)
+					if err != nil {
+						Panic("Unable to convert %s: %s\n", obj.GetObjectKind().GroupVersionKind().Kind, err)
+					}
+					desiredContent := dropEmpty(desired.Object)
+
+					// objects named by the server, such as PipelineRuns, are always added
+					var liveContent interface{}
+					var live *unstructured.Unstructured
+					if desired.GetGenerateName() == "" {
+						live, err = a.resource(desired).Get(desired.GetName(), metav1.GetOptions{})
+						if err == nil {
+							desiredContent = dropEmpty(mergeSecrets(desired, live).Object)
+							liveContent = projectOnto(desiredContent, live.Object)
+						} else if errors.IsNotFound(err) {
+							live = nil
+						} else {
+							Panic("Unable to get %s: %s\n", objectRef(desired), err)
+						}
+					}
+
+					change := ObjectChange{
+						Kind: desired.GetKind(),
+						Name: objectName(desired),
+					}
+
+					text := objectDiff(objectRef(desired), liveContent, desiredContent)
+					if live == nil {
+						change.Change = changeAdded
+					} else if text != "" {
+						change.Change = changeModified
+					} else {
+						change.Change = changeUnchanged
+					}
+
+					if change.Change != changeUnchanged {
+						differs = true
+					}
+					if !diffSummary {
+						fmt.Print(text)
+					}
+					changes = append(changes, change)
+				}
+			}
+
+			if diffSummary {
+				fmt.Print(GenerateOutput(changes))
+			}
+
+			if differs {
+				os.Exit(1)
+			}
+		},
+	}
+
+	addGenerateFlags(diffCmd)
+	diffCmd.Flags().BoolVarP(&pipelinerun, "pipelinerun", "p", false, "Flag to compare the PipelineRun")
+	diffCmd.Flags().BoolVarP(&diffSummary, "summary", "", false, "Print a summary of the changes per object in the output format instead of a unified diff")
+
+	return diffCmd
+}
+
+// objectDiff returns the unified diff between the YAML of the live and desired contents,
+// a nil live content means the object does not exist yet
+func objectDiff(ref string, live interface{}, desired interface{}) string {
+	to, err := yaml.Marshal(desired)
+	if err != nil {
+		Panic("Error generating YAML output: %s\n", err)
+	}
+
+	var from []byte
+	if live != nil {
+		if from, err = yaml.Marshal(live); err != nil {
+			Panic("Error generating YAML output: %s\n", err)
+		}
+	}
+
+	return unifiedDiff("live/"+ref, "generated/"+ref, splitLines(string(from)), splitLines(string(to)))
+}
+
+// dropEmpty removes empty maps, empty lists and zero scalars, which the server omits
+func dropEmpty(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			if d := dropEmpty(val); d != nil {
+				m[k] = d
+			}
+		}
+		if len(m) == 0 {
+			return nil
+		}
+		return m
+	case []interface{}:
+		if len(t) == 0 {
+			return nil
+		}
+		l := make([]interface{}, len(t))
+		for i := range t {
+			l[i] = dropEmpty(t[i])
+		}
+		return l
+	}
+
+	if v == nil || isZero(v) {
+		return nil
+	}
+
+	return v
+}
+
+// projectOnto keeps the parts of live that correspond to fields set in desired, so that
+// defaults and metadata populated by the server do not show up as differences
+func projectOnto(desired interface{}, live interface{}) interface{} {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		p := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			if lv, found := l[k]; found {
+				p[k] = projectOnto(v, lv)
+			}
+		}
+		return p
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return live
+		}
+		p := make([]interface{}, len(l))
+		for i := range l {
+			if i < len(d) {
+				p[i] = projectOnto(d[i], l[i])
+			} else {
+				p[i] = l[i]
+			}
+		}
+		return p
+	}
+
+	return live
+}
+
+// splitLines splits text into lines without the trailing empty line
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes a line-based diff from the longest common subsequence of a and b
+func diffLines(a []string, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		} else {
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{'+', b[j]})
+	}
+
+	return lines
+}
+
+// unifiedDiff formats the differences between a and b as a unified diff, empty if they are equal
+func unifiedDiff(fromName string, toName string, a []string, b []string) string {
+	lines := diffLines(a, b)
+	var out strings.Builder
+
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// extend the hunk while the next change is close enough to share context lines
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].op != ' ' {
+				end = j
+			} else if j-end > 2*diffContext {
+				break
+			}
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		stop := end + diffContext + 1
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+
+		aStart, bStart := countLines(lines[:start])
+		aCount, bCount := countLines(lines[start:stop])
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, l := range lines[start:stop] {
+			fmt.Fprintf(&out, "%c%s\n", l.op, l.text)
+		}
+
+		i = stop
+	}
+
+	return out.String()
+}
+
+// countLines returns how many lines of the old and the new text are part of lines
+func countLines(lines []diffLine) (int, int) {
+	a, b := 0, 0
+	for _, l := range lines {
+		if l.op != '+' {
+			a++
+		}
+		if l.op != '-' {
+			b++
+		}
+	}
+
+	return a, b
+}
+
+// hunkRange formats the start and length of a hunk, start being the number of preceding lines
+func hunkRange(before int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	} else if count == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
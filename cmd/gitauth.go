@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	gitSecret         string
+	gitServiceAccount string
+)
+
+// createGitServiceAccount returns the ServiceAccount listing the --git-secret that the runs of the
+// workflow clone with, nil without --git-secret. Tekton only uses the secret for git resources when
+// it has a tekton.dev/git-* annotation with the host it is for.
+func createGitServiceAccount(workflow string) *corev1.ServiceAccount {
+	if gitSecret != "" && gitServiceAccount != "" {
+		Panic("--git-secret and --git-service-account are mutually exclusive\n")
+	}
+	if gitSecret == "" {
+		return nil
+	}
+
+	account := corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ServiceAccount",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: generateName(kindServiceAccount, workflow+"-git"),
+		},
+		Secrets: []corev1.ObjectReference{{
+			Name: gitSecret,
+		}},
+	}
+	setProvenance(&account.ObjectMeta, workflow, "")
+
+	return &account
+}
+
+// runServiceAccount returns the ServiceAccount the runs of a workflow run and clone their repositories
+// as, empty for the namespace default
+func runServiceAccount(workflow string) string {
+	account := runOptions().ServiceAccountName
+	if account != "" && (gitSecret != "" || gitServiceAccount != "") {
+		Panic("--service-account replaces the git ServiceAccount, give it the git secret instead\n")
+	}
+	if rbacFlag && (account != "" || gitServiceAccount != "") {
+		Panic("--rbac generates the ServiceAccount of the runs, it cannot be used with another one\n")
+	}
+
+	if account != "" {
+		return account
+	}
+	if rbacFlag {
+		return runnerServiceAccount(workflow)
+	}
+	if gitServiceAccount != "" {
+		return gitServiceAccount
+	}
+	if gitSecret != "" {
+		return generateName(kindServiceAccount, workflow+"-git")
+	}
+
+	return ""
+}
@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/actions/workflow-parser/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Labels and annotations linking generated objects back to their source
+const (
+	labelManagedBy = "app.kubernetes.io/managed-by"
+	labelVersion   = "app.kubernetes.io/version"
+	labelWorkflow  = "aktion.triggermesh.io/workflow"
+	labelAction    = "aktion.triggermesh.io/action"
+	labelTrigger   = "aktion.triggermesh.io/trigger"
+	labelComponent = "app.kubernetes.io/component"
+
+	annotationSourceFile   = "aktion.triggermesh.io/source-file"
+	annotationAction       = "aktion.triggermesh.io/action"
+	annotationWorkflowHash = "aktion.triggermesh.io/workflow-hash"
+	annotationCommit       = "aktion.triggermesh.io/commit"
+	annotationRerunOf      = "aktion.triggermesh.io/rerun-of"
+
+	managedByAktion = "aktion"
+
+	// componentTransceiver marks the objects of launch, which delete and --prune leave alone
+	componentTransceiver = "transceiver"
+
+	// triggerManual and triggerRerun mark runs started from the CLI, the transceiver uses the GitHub event type
+	triggerManual = "manual"
+	triggerRerun  = "rerun"
+)
+
+var (
+	// workflowHashes are the hashes of each workflow and the actions it resolves, set by ParseData
+	workflowHashes map[string]string
+	// sourceFile is the workflow file relative to the root of its git repository, set by ParseData
+	sourceFile string
+)
+
+// setProvenance adds the aktion labels and annotations to an object. The action is optional
+// and only set for objects generated for a single action.
+func setProvenance(meta *metav1.ObjectMeta, workflow string, action string) {
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+
+	meta.Labels[labelManagedBy] = managedByAktion
+	meta.Labels[labelVersion] = labelValue(version)
+	meta.Labels[labelWorkflow] = convertName(workflow)
+
+	if action != "" {
+		meta.Labels[labelAction] = convertName(action)
+		meta.Annotations[annotationAction] = action
+	}
+
+	if sourceFile != "" {
+		meta.Annotations[annotationSourceFile] = sourceFile
+	}
+	if hash := workflowHashes[workflow]; hash != "" {
+		meta.Annotations[annotationWorkflowHash] = hash
+	}
+}
+
+// hashWorkflows returns the sha256 of each workflow of the file and of the actions it resolves, so
+// that editing a workflow does not change the annotations of the objects of the others
+func hashWorkflows(config *model.Configuration) map[string]string {
+	hashes := make(map[string]string, len(config.Workflows))
+
+	for _, w := range config.Workflows {
+		actions := make(map[string]*model.Action)
+		pending := append([]string{}, w.Resolves...)
+		for len(pending) > 0 {
+			a := config.GetAction(pending[0])
+			pending = pending[1:]
+			if a == nil || actions[a.Identifier] != nil {
+				continue
+			}
+			actions[a.Identifier] = a
+			pending = append(pending, a.Needs...)
+		}
+
+		// maps are marshalled with sorted keys, so the hash does not depend on the action order
+		data, err := json.Marshal(struct {
+			Workflow *model.Workflow
+			Actions  map[string]*model.Action
+		}{w, actions})
+		if err != nil {
+			Panic("Error hashing workflow %s: %s\n", w.Identifier, err)
+		}
+
+		sum := sha256.Sum256(data)
+		hashes[w.Identifier] = hex.EncodeToString(sum[:])
+	}
+
+	return hashes
+}
+
+// sourcePath returns the path of the workflow file relative to the root of the git repository it is
+// in, so that it does not depend on the directory aktion runs from. Files outside of a repository
+// are recorded with their absolute path.
+func sourcePath(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			if rel, err := filepath.Rel(dir, abs); err == nil {
+				return filepath.ToSlash(rel)
+			}
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	return abs
+}
+
+// workflowSelector returns the label selector matching every object aktion generated for a workflow
+func workflowSelector(workflow string) string {
+	return labels.Set{
+		labelManagedBy: managedByAktion,
+		labelWorkflow:  convertName(workflow),
+	}.String()
+}
+
+// labelValue makes a version string usable as a label value
+func labelValue(value string) string {
+	v := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '-'
+	}, value)
+
+	if len(v) > maxNameLength {
+		v = v[:maxNameLength]
+	}
+	v = strings.Trim(v, "-_.")
+
+	if v == "" {
+		return "unknown"
+	}
+
+	return v
+}
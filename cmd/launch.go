@@ -18,32 +18,63 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	sources "github.com/knative/eventing-contrib/github/pkg/apis/sources/v1alpha1"
 	serving "knative.dev/serving/pkg/apis/serving/v1alpha1"
 	"github.com/spf13/cobra"
+
+	"github.com/triggermesh/aktion/pkg/client"
+
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 var (
-	taskname string
+	taskname      string
+	launchApply   bool
+	launchTimeout time.Duration
 )
 
+// launchAPIs are the APIs serving the launch objects, with what has to be installed to get them
+var launchAPIs = []struct {
+	groupVersion string
+	resource     string
+	product      string
+}{
+	{serving.SchemeGroupVersion.String(), "services", "Knative Serving"},
+	{sources.SchemeGroupVersion.String(), "githubsources", "The eventing-contrib GitHub source"},
+}
+
 //NewLaunchCmd creates Launch command
-func NewLaunchCmd(repository *string) *cobra.Command {
+func NewLaunchCmd(kubeConfig *string, ns *string, repository *string) *cobra.Command {
 	launchCmd := &cobra.Command{
 		Use:   "launch",
 		Short: "Create a GitHub Source and a Transceiver to automatically generate TaskRuns",
 		Run: func(cmd *cobra.Command, args []string) {
 			repo = *repository
+			namespace = *ns
+
+			if launchApply {
+				if repo == "" {
+					Panic("The git flag must be specified to launch %s\n", taskname)
+				}
+
+				// the transceiver goes first so that the source has a sink to send events to
+				transceiver := CreateTransceiver(taskname)
+				source := CreateGithubSource(taskname, repo)
+				applyLaunch(*kubeConfig, launchRBAC(), &transceiver, &source)
+				return
+			}
 
 			if repo != "" {
-				fmt.Printf("%s", GenerateObjBreak(true))
-				fmt.Print(GenerateOutput(CreateGithubSource(taskname, repo)))
-				fmt.Printf("%s", GenerateObjBreak(false))
-				fmt.Print(GenerateOutput(CreateTransceiver(taskname)))
-				fmt.Printf("%s", GenerateObjLastBreak())
+				source := CreateGithubSource(taskname, repo)
+				transceiver := CreateTransceiver(taskname)
+				PrintObjects(append(launchRBAC(), &source, &transceiver))
 			}
 			/*
 				TODO handle empty repository way better
@@ -52,15 +83,18 @@ func NewLaunchCmd(repository *string) *cobra.Command {
 	}
 	launchCmd.Flags().StringVarP(&taskname, "task", "t", "", "Task Name to Trigger")
 	launchCmd.MarkFlagRequired("task")
+	launchCmd.Flags().BoolVarP(&launchApply, "apply", "a", false, "Create or update the objects in the cluster and wait for them to be ready")
+	launchCmd.Flags().DurationVarP(&launchTimeout, "timeout", "", 2*time.Minute, "With --apply, how long to wait for each object to be ready")
+	launchCmd.Flags().BoolVarP(&rbacFlag, "rbac", "", false, "Also generate the ServiceAccount, Role and RoleBinding the transceiver needs")
+	addRunFlags(launchCmd)
 
 	return launchCmd
 }
 
 //CreateGithubSource creates Github source based on provided Task name
 func CreateGithubSource(taskname string, repo string) sources.GitHubSource {
-	var tname = "taskrun-transceiver-"
-	tname += taskname
-	return sources.GitHubSource{
+	tname := transceiverName(taskname)
+	source := sources.GitHubSource{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "GitHubSource",
 			APIVersion: sources.SchemeGroupVersion.String(),
@@ -94,12 +128,15 @@ func CreateGithubSource(taskname string, repo string) sources.GitHubSource {
 			},
 		},
 	}
+	setProvenance(&source.ObjectMeta, taskname, "")
+	setTransceiverComponent(&source.ObjectMeta)
+
+	return source
 }
 
 //CreateTransceiver creates Transceiver object
 func CreateTransceiver(taskname string) serving.Service {
-	var tname = "taskrun-transceiver-"
-	tname += taskname
+	tname := transceiverName(taskname)
 
 	serviceContainer := &corev1.Container{
 		Image: "gcr.io/triggermesh/transceiver-60a15ebeaf09df9f7ef1bd5f51a22549:latest",
@@ -123,13 +160,20 @@ func CreateTransceiver(taskname string) serving.Service {
 		},
 	}
 
+	// the transceiver gives the TaskRuns it creates the ServiceAccount and pod template of the runs
+	serviceContainer.Env = append(serviceContainer.Env, transceiverRunEnv(taskname)...)
+
 	revisionSpec := &serving.RevisionTemplateSpec{
 		Spec: serving.RevisionSpec{
 			DeprecatedContainer: serviceContainer,
 		},
 	}
 
-	return serving.Service{
+	if rbacFlag {
+		revisionSpec.Spec.ServiceAccountName = tname
+	}
+
+	service := serving.Service{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Service",
 			APIVersion: "serving.knative.dev/v1alpha1",
@@ -148,4 +192,128 @@ func CreateTransceiver(taskname string) serving.Service {
 			},
 		},
 	}
+	setProvenance(&service.ObjectMeta, taskname, "")
+	setTransceiverComponent(&service.ObjectMeta)
+
+	return service
+}
+
+// transceiverName returns the name of the transceiver creating the TaskRuns of a Task
+func transceiverName(taskname string) string {
+	return "taskrun-transceiver-" + taskname
+}
+
+// setTransceiverComponent labels an object as part of the transceiver. It shares the workflow
+// label of the Task, but belongs to launch, so delete and --prune leave it alone.
+func setTransceiverComponent(obj metav1.Object) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[labelComponent] = componentTransceiver
+	obj.SetLabels(labels)
+}
+
+// launchRBAC returns the RBAC objects of the transceiver with --rbac
+func launchRBAC() []runtime.Object {
+	if !rbacFlag {
+		return nil
+	}
+
+	return createTransceiverRBAC(taskname)
+}
+
+// applyLaunch creates or updates the prerequisites, such as RBAC objects, and the launch objects,
+// then waits for each of the launch objects to be ready
+func applyLaunch(kubeConfig string, prerequisites []runtime.Object, objects ...runtime.Object) {
+	clientSet := newClientSet(kubeConfig)
+	checkLaunchAPIs(clientSet)
+
+	a := applierFor(clientSet)
+	if err := a.applyWorkflow(taskname, append(prerequisites, objects...), false); err != nil {
+		a.rollback()
+		Panic("Unable to launch %s: %s\n", taskname, err)
+	}
+
+	for _, obj := range objects {
+		desired, err := toUnstructured(obj)
+		if err != nil {
+			Panic("Unable to convert %s: %s\n", obj.GetObjectKind().GroupVersionKind().Kind, err)
+		}
+		waitReady(a, desired)
+	}
+}
+
+// checkLaunchAPIs makes sure the cluster serves the Knative APIs the launch objects need
+func checkLaunchAPIs(clientSet client.ConfigSet) {
+	for _, api := range launchAPIs {
+		resources, err := clientSet.Core.Discovery().ServerResourcesForGroupVersion(api.groupVersion)
+		if err != nil && !errors.IsNotFound(err) {
+			Panic("Unable to discover %s: %s\n", api.groupVersion, err)
+		}
+
+		found := false
+		if err == nil {
+			for _, r := range resources.APIResources {
+				found = found || r.Name == api.resource
+			}
+		}
+
+		if !found {
+			Panic("%s is not installed: the cluster does not serve %s in %s\n", api.product, api.resource, api.groupVersion)
+		}
+	}
+}
+
+// waitReady polls a Knative object until its Ready condition reflects the applied generation and is
+// no longer unknown, then reports the object's URL
+func waitReady(a *applier, desired *unstructured.Unstructured) {
+	ref := objectRef(desired)
+
+	var live *unstructured.Unstructured
+	err := wait.PollImmediate(time.Second, launchTimeout, func() (bool, error) {
+		var err error
+		if live, err = a.resource(desired).Get(desired.GetName(), metav1.GetOptions{}); err != nil {
+			return false, err
+		}
+
+		observed, _, _ := unstructured.NestedInt64(live.Object, "status", "observedGeneration")
+		status, _ := readyCondition(live)
+		return observed >= live.GetGeneration() && status != "" && status != string(corev1.ConditionUnknown), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		_, message := readyCondition(live)
+		Panic("%s is not ready after %s: %s\n", ref, launchTimeout, message)
+	} else if err != nil {
+		Panic("Unable to get %s: %s\n", ref, err)
+	}
+
+	if status, message := readyCondition(live); status != string(corev1.ConditionTrue) {
+		Panic("%s is not ready: %s\n", ref, message)
+	}
+
+	if url, _, _ := unstructured.NestedString(live.Object, "status", "url"); url != "" {
+		fmt.Printf("%s ready at %s\n", ref, url)
+	} else if sink, _, _ := unstructured.NestedString(live.Object, "status", "sinkUri"); sink != "" {
+		fmt.Printf("%s ready, sending events to %s\n", ref, sink)
+	} else {
+		fmt.Printf("%s ready\n", ref)
+	}
+}
+
+// readyCondition returns the status and message of the Ready condition of a Knative object
+func readyCondition(u *unstructured.Unstructured) (string, string) {
+	conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+
+		status, _ := cond["status"].(string)
+		message, _ := cond["message"].(string)
+		return status, message
+	}
+
+	return "", ""
 }
@@ -0,0 +1,226 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/triggermesh/aktion/pkg/client"
+
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Labels Tekton sets on TaskRuns
+const (
+	// tektonPipelineRunLabel holds the name of the PipelineRun that created the TaskRun
+	tektonPipelineRunLabel = "tekton.dev/pipelineRun"
+)
+
+var (
+	logsPipelineRun string
+	logsTaskRun     string
+	logsActions     []string
+	logsFollow      bool
+	logsTimestamps  bool
+)
+
+//NewLogsCmd creates logs command
+func NewLogsCmd(kubeConfig *string, ns *string, gitRepository *string) *cobra.Command {
+	logsCmd := &cobra.Command{
+		Use:   "logs [workflow]",
+		Short: "Print the logs of the latest or a named run of a workflow, grouped per action",
+		Long: "Print the logs of the latest or a named run of a workflow, grouped per action.\n" +
+			"Without --pipelinerun or --taskrun the latest PipelineRun of the workflow is used, or the\n" +
+			"latest TaskRun of the workflow when the workflow has only been triggered by the transceiver.\n" +
+			"When following, lines are prefixed with their action instead of being grouped.",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config := ParseData()
+			resetGeneration()
+			namespace = *ns
+			repo = *gitRepository
+
+			workflow := selectWorkflow(config, args)
+			// generating the workflow registers the step names used to map containers back to actions
+			generateObjects(extractTasks(workflow, config), workflow, config)
+
+			clientSet := newClientSet(*kubeConfig)
+			printer := &logPrinter{
+				core:       clientSet.CoreStream,
+				namespace:  namespace,
+				follow:     logsFollow,
+				timestamps: logsTimestamps,
+				grouped:    !logsFollow,
+				actions:    make(map[string]bool),
+				out:        os.Stdout,
+			}
+			for _, a := range logsActions {
+				printer.actions[a] = true
+			}
+
+			if logsTaskRun != "" {
+				printTaskRun(clientSet, printer, logsTaskRun)
+				return
+			}
+
+			pr := findPipelineRun(clientSet, workflow, logsPipelineRun)
+			if pr == nil {
+				tr := latestTaskRun(clientSet, workflow)
+				if tr == nil {
+					Panic("No runs found for workflow %s\n", workflow)
+				}
+				printTaskRun(clientSet, printer, tr.Name)
+				return
+			}
+
+			if logsFollow {
+				followPipelineRun(clientSet, pr.Name, printer)
+				return
+			}
+
+			for _, pod := range pipelineRunPods(pr) {
+				if err := printer.printPod(pod); err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to get logs of pod %s: %s\n", pod, err)
+				}
+			}
+		},
+	}
+
+	logsCmd.Flags().StringVarP(&logsPipelineRun, "pipelinerun", "", "", "Name of the PipelineRun to print the logs of")
+	logsCmd.Flags().StringVarP(&logsTaskRun, "taskrun", "", "", "Name of the TaskRun to print the logs of")
+	logsCmd.Flags().StringSliceVarP(&logsActions, "action", "", nil, "Only print the logs of these action identifiers")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "", false, "Follow the logs until the run completes")
+	logsCmd.Flags().BoolVarP(&logsTimestamps, "timestamps", "", false, "Prefix each line with its timestamp")
+
+	return logsCmd
+}
+
+// printTaskRun prints the logs of a TaskRun, waiting for its pod to be created when following
+func printTaskRun(clientSet client.ConfigSet, printer *logPrinter, name string) {
+	taskRuns := clientSet.Pipeline.TektonV1alpha1().TaskRuns(namespace)
+
+	var podName string
+	err := wait.PollImmediateInfinite(time.Second, func() (bool, error) {
+		tr, err := taskRuns.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		podName = tr.Status.PodName
+		return podName != "" || !printer.follow, nil
+	})
+	if err != nil {
+		Panic("Unable to get task run %s: %s\n", name, err)
+	}
+
+	if podName == "" {
+		Panic("Task run %s has no pod yet\n", name)
+	}
+
+	if err := printer.printPod(podName); err != nil {
+		Panic("Unable to get logs of pod %s: %s\n", podName, err)
+	}
+}
+
+// findPipelineRun returns the named PipelineRun, or the latest one of the workflow when name is
+// empty. It returns nil when the workflow has no PipelineRun.
+func findPipelineRun(clientSet client.ConfigSet, workflow string, name string) *pipeline.PipelineRun {
+	pipelineRuns := clientSet.Pipeline.TektonV1alpha1().PipelineRuns(namespace)
+
+	if name != "" {
+		pr, err := pipelineRuns.Get(name, metav1.GetOptions{})
+		if err != nil {
+			Panic("Unable to get pipeline run %s: %s\n", name, err)
+		}
+		return pr
+	}
+
+	list, err := pipelineRuns.List(metav1.ListOptions{LabelSelector: workflowSelector(workflow)})
+	if err != nil {
+		Panic("Unable to list pipeline runs: %s\n", err)
+	}
+
+	var latest *pipeline.PipelineRun
+	for i := range list.Items {
+		if latest == nil || latest.CreationTimestamp.Before(&list.Items[i].CreationTimestamp) {
+			latest = &list.Items[i]
+		}
+	}
+
+	return latest
+}
+
+// latestTaskRun returns the latest TaskRun started outside of a PipelineRun for the workflow, nil if there is none
+func latestTaskRun(clientSet client.ConfigSet, workflow string) *pipeline.TaskRun {
+	selector := workflowSelector(workflow)
+
+	list, err := clientSet.Pipeline.TektonV1alpha1().TaskRuns(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		Panic("Unable to list task runs: %s\n", err)
+	}
+
+	var latest *pipeline.TaskRun
+	for i := range list.Items {
+		if _, ok := list.Items[i].Labels[tektonPipelineRunLabel]; ok {
+			continue
+		}
+		if latest == nil || latest.CreationTimestamp.Before(&list.Items[i].CreationTimestamp) {
+			latest = &list.Items[i]
+		}
+	}
+
+	return latest
+}
+
+// pipelineRunPods returns the pods of the TaskRuns of a PipelineRun in the order they started
+func pipelineRunPods(pr *pipeline.PipelineRun) []string {
+	pods := make([]string, 0, len(pr.Status.TaskRuns))
+	for _, s := range taskRunStatuses(pr) {
+		if s.PodName != "" {
+			pods = append(pods, s.PodName)
+		}
+	}
+
+	return pods
+}
+
+// taskRunStatuses returns the statuses of the TaskRuns of a PipelineRun in the order they
+// started, TaskRuns that have not started yet come last
+func taskRunStatuses(pr *pipeline.PipelineRun) []*pipeline.TaskRunStatus {
+	statuses := make([]*pipeline.TaskRunStatus, 0, len(pr.Status.TaskRuns))
+	for _, tr := range pr.Status.TaskRuns {
+		if tr.Status != nil {
+			statuses = append(statuses, tr.Status)
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].StartTime == nil || statuses[j].StartTime == nil {
+			return statuses[j].StartTime == nil && statuses[i].StartTime != nil
+		}
+		return statuses[i].StartTime.Before(statuses[j].StartTime)
+	})
+
+	return statuses
+}
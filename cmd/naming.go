@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	// maxNameLength is the DNS-1123 label limit, which also applies to step container names once
+	// they are prefixed with stepContainerPrefix
+	maxNameLength = 63
+	// nameHashLength is the number of hex characters appended to truncated names
+	nameHashLength = 8
+	// defaultName is used when nothing valid is left after sanitising
+	defaultName = "aktion"
+	// generateNameSuffixLength is the length of the random suffix the server appends to a generateName
+	generateNameSuffixLength = 5
+)
+
+// Kinds tracked by the naming registry. Steps running a workflow action are tracked as actions,
+// the other steps of the build tasks as steps.
+const (
+	kindTask             = "Task"
+	kindStep             = "Step"
+	kindAction           = "Action"
+	kindPipeline         = "Pipeline"
+	kindPipelineResource = "PipelineResource"
+	kindPipelineRun      = "PipelineRun"
+	kindServiceAccount   = "ServiceAccount"
+)
+
+// generatedNames maps a kind to the generated names and the original identifiers they came from
+var generatedNames map[string]map[string]string
+
+// resetNames clears the naming registry before a new conversion
+func resetNames() {
+	generatedNames = make(map[string]map[string]string)
+}
+
+// generateName converts original into a valid name and records it for the given kind.
+// Two different identifiers producing the same name for the same kind is a fatal error.
+func generateName(kind string, original string) string {
+	name := convertName(original)
+	if kind == kindStep || kind == kindAction {
+		name = shortenName(original, maxNameLength-len(stepContainerPrefix))
+	}
+
+	if generatedNames == nil {
+		resetNames()
+	}
+	if generatedNames[kind] == nil {
+		generatedNames[kind] = make(map[string]string)
+	}
+
+	if prev, ok := generatedNames[kind][name]; ok && prev != original {
+		Panic("Name collision: %q and %q both generate the %s name %q\n", prev, original, kind, name)
+	}
+	generatedNames[kind][name] = original
+
+	return name
+}
+
+// convertName turns an arbitrary identifier into a DNS-1123 label. Names that have to be
+// shortened keep a prefix of the sanitised name followed by a hash of the original identifier.
+func convertName(name string) string {
+	return shortenName(name, maxNameLength)
+}
+
+// shortenName sanitises the name like convertName, within limit characters. Steps are limited to
+// less than a label, as Tekton runs them in containers named with stepContainerPrefix.
+func shortenName(name string, limit int) string {
+	n := sanitizeName(name)
+	if len(n) <= limit {
+		return n
+	}
+
+	prefix := strings.TrimRight(n[:limit-nameHashLength-1], "-")
+	return prefix + "-" + nameHash(name)
+}
+
+// generateNamePrefix returns a generateName prefix for name that leaves room for the random
+// suffix added by the server
+func generateNamePrefix(name string) string {
+	limit := maxNameLength - generateNameSuffixLength - 1
+	if len(name) > limit {
+		name = strings.TrimRight(name[:limit], "-")
+	}
+
+	return name + "-"
+}
+
+// sanitizeName lowercases the name and replaces every run of characters outside [a-z0-9] with a dash
+func sanitizeName(name string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	n := strings.Trim(b.String(), "-")
+	if n == "" {
+		return defaultName
+	}
+
+	return n
+}
+
+// nameHash returns a short, stable hash of the original identifier
+func nameHash(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])[:nameHashLength]
+}
+
+// printNameMapping writes every generated name and its original identifier as a table
+func printNameMapping(w io.Writer) {
+	kinds := make([]string, 0, len(generatedNames))
+	for k := range generatedNames {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tORIGINAL")
+	for _, k := range kinds {
+		names := make([]string, 0, len(generatedNames[k]))
+		for n := range generatedNames[k] {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		for _, n := range names {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", k, n, generatedNames[k][n])
+		}
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// stepContainerPrefix is the prefix of the containers Tekton runs the steps of a Task in
+const stepContainerPrefix = "step-"
+
+// logPrinter writes the logs of step containers line by line, prefixed with the action each step
+// runs or, when grouped, under a header per action
+type logPrinter struct {
+	core       kubernetes.Interface
+	namespace  string
+	follow     bool
+	timestamps bool
+	grouped    bool
+	// actions limits the output to the steps of these actions when not empty
+	actions map[string]bool
+	out     io.Writer
+	mu      sync.Mutex
+}
+
+// printPod writes the logs of every step container of a pod in the order the steps run
+func (p *logPrinter) printPod(podName string) error {
+	pod, err := p.core.CoreV1().Pods(p.namespace).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range pod.Spec.Containers {
+		if !strings.HasPrefix(c.Name, stepContainerPrefix) {
+			continue
+		}
+
+		// without following, steps that never started have no logs to print
+		if !p.follow && !containerStarted(pod, c.Name) {
+			continue
+		}
+
+		if err := p.printContainer(podName, c.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// printContainer writes the logs of a step container, waiting for it to start when following
+func (p *logPrinter) printContainer(podName string, container string) error {
+	action := actionName(container)
+	if len(p.actions) > 0 && !p.actions[action] {
+		return nil
+	}
+
+	if p.follow {
+		started, err := p.waitForContainer(podName, container)
+		if err != nil || !started {
+			return err
+		}
+	}
+
+	stream, err := p.core.CoreV1().Pods(p.namespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container:  container,
+		Follow:     p.follow,
+		Timestamps: p.timestamps,
+	}).Stream()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// grouped output keeps the lock for the whole container so that groups are not interleaved
+	if p.grouped {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		fmt.Fprintf(p.out, "▶ %s\n", action)
+	}
+
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		if p.grouped {
+			fmt.Fprintf(p.out, "  %s\n", scanner.Text())
+			continue
+		}
+
+		p.mu.Lock()
+		fmt.Fprintf(p.out, "[%s] %s\n", action, scanner.Text())
+		p.mu.Unlock()
+	}
+
+	return scanner.Err()
+}
+
+// waitForContainer polls the pod until the container is running or has terminated. It reports
+// false when the pod completes without starting the container, e.g. after an earlier step failed.
+func (p *logPrinter) waitForContainer(podName string, container string) (bool, error) {
+	started := false
+
+	err := wait.PollImmediateInfinite(time.Second, func() (bool, error) {
+		pod, err := p.core.CoreV1().Pods(p.namespace).Get(podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		if containerStarted(pod, container) {
+			started = true
+			return true, nil
+		}
+
+		return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed, nil
+	})
+
+	return started, err
+}
+
+// containerStarted reports whether the container of the pod is running or has terminated
+func containerStarted(pod *corev1.Pod, container string) bool {
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.Name == container {
+			return s.State.Running != nil || s.State.Terminated != nil
+		}
+	}
+
+	return false
+}
+
+// actionName maps a step container back to the workflow action it runs
+func actionName(container string) string {
+	step := strings.TrimPrefix(container, stepContainerPrefix)
+	if original, ok := generatedNames[kindAction][step]; ok {
+		return original
+	}
+
+	return step
+}
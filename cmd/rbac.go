@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var rbacFlag bool
+
+// createRunnerServiceAccount returns the ServiceAccount the runs of a workflow run as with --rbac,
+// listing the --git-secret. It gets no Role: runs need no API access, and Tekton's controller reads
+// the secrets of the account itself to give them to the clones.
+func createRunnerServiceAccount(workflow string) *corev1.ServiceAccount {
+	account := newServiceAccount(runnerServiceAccount(workflow), workflow)
+	if gitSecret != "" {
+		account.Secrets = []corev1.ObjectReference{{
+			Name: gitSecret,
+		}}
+	}
+
+	return account
+}
+
+// createTransceiverRBAC returns the ServiceAccount of the transceiver of a Task, with a Role that
+// lets it read the ConfigMap of its TaskRuns and the Task, and create the TaskRuns
+func createTransceiverRBAC(taskname string) []runtime.Object {
+	account := newServiceAccount(transceiverName(taskname), taskname)
+
+	objects := bindRole(account, taskname, []rbacv1.PolicyRule{{
+		APIGroups:     []string{""},
+		Resources:     []string{"configmaps"},
+		ResourceNames: []string{taskname},
+		Verbs:         []string{"get"},
+	}, {
+		APIGroups:     []string{"tekton.dev"},
+		Resources:     []string{"tasks"},
+		ResourceNames: []string{taskname},
+		Verbs:         []string{"get"},
+	}, {
+		APIGroups: []string{"tekton.dev"},
+		Resources: []string{"taskruns"},
+		Verbs:     []string{"create"},
+	}})
+	for _, obj := range objects {
+		setTransceiverComponent(obj.(metav1.Object))
+	}
+
+	return objects
+}
+
+// runnerServiceAccount returns the name of the ServiceAccount generated for the runs of a workflow
+func runnerServiceAccount(workflow string) string {
+	return generateName(kindServiceAccount, workflow+"-runner")
+}
+
+// newServiceAccount returns a ServiceAccount labelled for the workflow
+func newServiceAccount(name string, workflow string) *corev1.ServiceAccount {
+	account := corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ServiceAccount",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	setProvenance(&account.ObjectMeta, workflow, "")
+
+	return &account
+}
+
+// bindRole returns the account followed by a namespaced Role with the rules and a RoleBinding
+// granting it to the account, all three sharing the account's name
+func bindRole(account *corev1.ServiceAccount, workflow string, rules []rbacv1.PolicyRule) []runtime.Object {
+	role := rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Role",
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: account.Name,
+		},
+		Rules: rules,
+	}
+	setProvenance(&role.ObjectMeta, workflow, "")
+
+	binding := rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "RoleBinding",
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: account.Name,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      account.Name,
+			Namespace: namespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		},
+	}
+	setProvenance(&binding.ObjectMeta, workflow, "")
+
+	return []runtime.Object{account, &role, &binding}
+}
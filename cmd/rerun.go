@@ -0,0 +1,470 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/triggermesh/aktion/pkg/client"
+
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"knative.dev/pkg/apis"
+)
+
+// tektonLabelPrefix is the prefix of the labels Tekton sets on runs, which are not copied to reruns
+const tektonLabelPrefix = "tekton.dev/"
+
+var (
+	rerunPipelineRun string
+	rerunTaskRun     string
+	rerunFromFailed  bool
+	rerunFollow      bool
+)
+
+//NewRerunCmd creates rerun command
+func NewRerunCmd(kubeConfig *string, ns *string, gitRepository *string) *cobra.Command {
+	rerunCmd := &cobra.Command{
+		Use:   "rerun [workflow]",
+		Short: "Start a new run of a workflow with the resources and revision of a previous run",
+		Long: "Start a new run with the resources and revision of the latest run of a workflow, or of the\n" +
+			"run named with --pipelinerun or --taskrun. With --from-failed the actions before the first\n" +
+			"failed one are skipped. Actions run as steps of the same pod, so files written to the\n" +
+			"workspace by the skipped actions are not available to the rerun.",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config := ParseData()
+			resetGeneration()
+			namespace = *ns
+			repo = *gitRepository
+
+			workflow := selectWorkflow(config, args)
+			tasks := extractTasks(workflow, config)
+			// generating the workflow registers the step names used to find the failed action
+			generateObjects(tasks, workflow, config)
+
+			clientSet := newClientSet(*kubeConfig)
+
+			if rerunTaskRun == "" {
+				if pr := findPipelineRun(clientSet, workflow, rerunPipelineRun); pr != nil {
+					rerunPipeline(clientSet, workflow, tasks, pr)
+					return
+				}
+			}
+
+			var tr *pipeline.TaskRun
+			if rerunTaskRun != "" {
+				var err error
+				if tr, err = clientSet.Pipeline.TektonV1alpha1().TaskRuns(namespace).Get(rerunTaskRun, metav1.GetOptions{}); err != nil {
+					Panic("Unable to get task run %s: %s\n", rerunTaskRun, err)
+				}
+			} else if tr = latestTaskRun(clientSet, workflow); tr == nil {
+				Panic("No runs found for workflow %s\n", workflow)
+			}
+
+			rerunTask(clientSet, workflow, tasks, tr)
+		},
+	}
+
+	rerunCmd.Flags().StringVarP(&rerunPipelineRun, "pipelinerun", "", "", "Name of the PipelineRun to rerun")
+	rerunCmd.Flags().StringVarP(&rerunTaskRun, "taskrun", "", "", "Name of the TaskRun to rerun")
+	rerunCmd.Flags().BoolVarP(&rerunFromFailed, "from-failed", "", false, "Only run the actions from the first one that failed")
+	rerunCmd.Flags().BoolVarP(&rerunFollow, "follow", "", false, "Follow the logs of the new run until it completes")
+	rerunCmd.Flags().BoolVarP(&skipValidation, "skip-validation", "", false, "Do not validate the Task and Pipeline generated with --from-failed before applying them")
+
+	return rerunCmd
+}
+
+// rerunPipeline creates a copy of a previous PipelineRun, pointing it at a Pipeline that starts
+// at the first failed action with --from-failed
+func rerunPipeline(clientSet client.ConfigSet, workflow string, tasks Tasks, previous *pipeline.PipelineRun) {
+	run := pipeline.PipelineRun{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PipelineRun",
+			APIVersion: "tekton.dev/v1alpha1",
+		},
+		ObjectMeta: rerunMeta(previous.ObjectMeta, generateName(kindPipelineRun, workflow+"-pipeline-run")),
+		Spec:       *previous.Spec.DeepCopy(),
+	}
+	run.Spec.Status = ""
+	// the rerun pushes its images to a tag of its own
+	run.Spec.Params = createPipelineRun(tasks, workflow, repo, workflow).Spec.Params
+
+	if rerunFromFailed {
+		outcomes := skippedOutcomes(workflow, tasks, previous.Spec.PipelineRef.Name)
+		outcomes = append(outcomes, buildFailures(tasks, previous)...)
+		outcomes = append(outcomes, pipelineRunSummary(workflow, previous).Actions...)
+		remaining, _, line := applyFromFailed(clientSet, workflow, tasks, outcomes)
+		run.Spec.PipelineRef.Name = line.Name
+		// the trimmed Pipeline only builds the images of the remaining actions
+		bindings := createPipelineRun(remaining, workflow, repo, workflow)
+		run.Spec.Resources = bindings.Spec.Resources
+		run.Spec.ServiceAccounts = bindings.Spec.ServiceAccounts
+		run.Spec.Params = bindings.Spec.Params
+	}
+
+	pinned := pinRevision(clientSet, workflow, previous.Annotations[annotationCommit])
+	if pinned != "" {
+		refs := make([]*pipeline.PipelineResourceRef, 0, len(run.Spec.Resources))
+		for i := range run.Spec.Resources {
+			refs = append(refs, &run.Spec.Resources[i].ResourceRef)
+		}
+		rebindRevision(workflow, pinned, refs)
+	}
+
+	created, err := clientSet.Pipeline.TektonV1alpha1().PipelineRuns(namespace).Create(&run)
+	if err != nil {
+		deletePinned(clientSet, pinned)
+		Panic("Unable to create pipeline run: %s\n", err)
+	}
+	fmt.Printf("pipelinerun/%s created\n", created.Name)
+
+	if pinned != "" {
+		ownPinned(clientSet, pinned, run.TypeMeta, created.ObjectMeta)
+	}
+
+	if rerunFollow {
+		printer := &logPrinter{
+			core:      clientSet.CoreStream,
+			namespace: namespace,
+			follow:    true,
+			out:       os.Stdout,
+		}
+
+		finished := followPipelineRun(clientSet, created.Name, printer)
+		reportOutcome(kindPipelineRun, finished.Name, finished.Status.GetCondition(apis.ConditionSucceeded))
+	}
+}
+
+// rerunTask creates a copy of a previous TaskRun, pointing it at a Task that starts at the first
+// failed action with --from-failed
+func rerunTask(clientSet client.ConfigSet, workflow string, tasks Tasks, previous *pipeline.TaskRun) {
+	run := pipeline.TaskRun{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "TaskRun",
+			APIVersion: "tekton.dev/v1alpha1",
+		},
+		ObjectMeta: rerunMeta(previous.ObjectMeta, convertName(workflow+"-task-run")),
+		Spec:       *previous.Spec.DeepCopy(),
+	}
+	run.Spec.Status = ""
+
+	if rerunFromFailed {
+		if run.Spec.TaskRef == nil {
+			Panic("Task run %s does not reference a Task, --from-failed is not supported\n", previous.Name)
+		}
+
+		outcomes := append(skippedOutcomes(workflow, tasks, run.Spec.TaskRef.Name), stepOutcomes(previous.Status.Steps)...)
+		_, task, _ := applyFromFailed(clientSet, workflow, tasks, outcomes)
+		run.Spec.TaskRef.Name = task.Name
+		run.Spec.Inputs.Resources = declaredResources(task, run.Spec.Inputs.Resources)
+	}
+
+	pinned := pinRevision(clientSet, workflow, previous.Annotations[annotationCommit])
+	if pinned != "" {
+		refs := make([]*pipeline.PipelineResourceRef, 0, len(run.Spec.Inputs.Resources))
+		for i := range run.Spec.Inputs.Resources {
+			refs = append(refs, &run.Spec.Inputs.Resources[i].ResourceRef)
+		}
+		rebindRevision(workflow, pinned, refs)
+	}
+
+	taskRuns := clientSet.Pipeline.TektonV1alpha1().TaskRuns(namespace)
+	created, err := taskRuns.Create(&run)
+	if err != nil {
+		deletePinned(clientSet, pinned)
+		Panic("Unable to create task run: %s\n", err)
+	}
+	fmt.Printf("taskrun/%s created\n", created.Name)
+
+	if pinned != "" {
+		ownPinned(clientSet, pinned, run.TypeMeta, created.ObjectMeta)
+	}
+
+	if rerunFollow {
+		printer := &logPrinter{
+			core:      clientSet.CoreStream,
+			namespace: namespace,
+			follow:    true,
+			out:       os.Stdout,
+		}
+		printTaskRun(clientSet, printer, created.Name)
+
+		// the logs end with the last step, the TaskRun completes shortly after
+		var finished *pipeline.TaskRun
+		err := wait.PollImmediateInfinite(time.Second, func() (bool, error) {
+			var err error
+			finished, err = taskRuns.Get(created.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			return runCompleted(finished.Status.GetCondition(apis.ConditionSucceeded)), nil
+		})
+		if err != nil {
+			Panic("Unable to get task run %s: %s\n", created.Name, err)
+		}
+		reportOutcome("TaskRun", finished.Name, finished.Status.GetCondition(apis.ConditionSucceeded))
+	}
+}
+
+// rerunMeta returns the metadata of a rerun, keeping the labels and annotations aktion set on the
+// previous run and generating a name from prefix
+func rerunMeta(previous metav1.ObjectMeta, prefix string) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{
+		GenerateName: generateNamePrefix(prefix),
+		Labels:       make(map[string]string),
+		Annotations:  make(map[string]string),
+	}
+
+	for k, v := range previous.Labels {
+		if !strings.HasPrefix(k, tektonLabelPrefix) {
+			meta.Labels[k] = v
+		}
+	}
+	for k, v := range previous.Annotations {
+		meta.Annotations[k] = v
+	}
+
+	meta.Labels[labelTrigger] = triggerRerun
+	meta.Annotations[annotationRerunOf] = previous.Name
+
+	return meta
+}
+
+// pinRevision creates a copy of the workflow's git resource at the commit the previous run used,
+// for the rerun only, as the branch or tag of the shared resource may point at another commit by
+// now. It returns the name of the copy, empty when the commit is unchanged or unknown.
+func pinRevision(clientSet client.ConfigSet, workflow string, previous string) string {
+	if previous == "" {
+		return ""
+	}
+
+	resources := clientSet.Pipeline.TektonV1alpha1().PipelineResources(namespace)
+	name := convertName(workflow)
+
+	resource, err := resources.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return ""
+	} else if err != nil {
+		Panic("Unable to get pipelineresource/%s: %s\n", name, err)
+	}
+	current := resourceParam(resource, "revision")
+	if current == previous || resolveRevision(resourceParam(resource, "url"), current) == previous {
+		return ""
+	}
+
+	// the copy has no workflow label, it is deleted with the rerun that owns it
+	pinned := pipeline.PipelineResource{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: generateNamePrefix(name + "-rerun"),
+			Labels:       map[string]string{labelManagedBy: managedByAktion},
+			Annotations:  map[string]string{annotationCommit: previous},
+		},
+		Spec: *resource.Spec.DeepCopy(),
+	}
+	for i := range pinned.Spec.Params {
+		if pinned.Spec.Params[i].Name == "revision" {
+			pinned.Spec.Params[i].Value = previous
+		}
+	}
+
+	created, err := resources.Create(&pinned)
+	if err != nil {
+		Panic("Unable to create a pipelineresource at revision %s: %s\n", previous, err)
+	}
+	fmt.Printf("pipelineresource/%s created at revision %s\n", created.Name, previous)
+
+	return created.Name
+}
+
+// rebindRevision points the bindings of the workflow's git resource at its pinned copy
+func rebindRevision(workflow string, pinned string, refs []*pipeline.PipelineResourceRef) {
+	for _, ref := range refs {
+		if ref.Name == convertName(workflow) {
+			ref.Name = pinned
+		}
+	}
+}
+
+// deletePinned deletes the pinned copy of the git resource of a rerun that could not be created
+func deletePinned(clientSet client.ConfigSet, pinned string) {
+	if pinned == "" {
+		return
+	}
+
+	err := clientSet.Pipeline.TektonV1alpha1().PipelineResources(namespace).Delete(pinned, &metav1.DeleteOptions{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to delete pipelineresource/%s: %s\n", pinned, err)
+	}
+}
+
+// ownPinned makes the rerun the owner of the pinned copy of the git resource, so that it is
+// garbage collected with the rerun
+func ownPinned(clientSet client.ConfigSet, pinned string, kind metav1.TypeMeta, run metav1.ObjectMeta) {
+	resources := clientSet.Pipeline.TektonV1alpha1().PipelineResources(namespace)
+	owner := metav1.OwnerReference{
+		APIVersion: kind.APIVersion,
+		Kind:       kind.Kind,
+		Name:       run.Name,
+		UID:        run.UID,
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		resource, err := resources.Get(pinned, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		resource.OwnerReferences = append(resource.OwnerReferences, owner)
+		_, err = resources.Update(resource)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to set the owner of pipelineresource/%s: %s\n", pinned, err)
+	}
+}
+
+// applyFromFailed applies a Task and a Pipeline running the actions of the workflow from the first
+// one that did not succeed in outcomes, and returns them with the remaining actions. Outcomes must
+// have a failed action, see buildFailures for the runs whose builds failed.
+func applyFromFailed(clientSet client.ConfigSet, workflow string, tasks Tasks, outcomes []ActionOutcome) (Tasks, pipeline.Task, pipeline.Pipeline) {
+	succeeded := make(map[string]bool)
+	failures := 0
+	for _, o := range outcomes {
+		if o.Status == outcomeSucceeded {
+			succeeded[o.Action] = true
+		} else if o.Status == outcomeFailed {
+			failures++
+		}
+	}
+	if failures == 0 {
+		Panic("The previous run of workflow %s has no failed action\n", workflow)
+	}
+
+	// actions run in order, the ones after the failed one did not run either
+	remaining := Tasks{Identifier: tasks.Identifier}
+	for i, t := range tasks.Task {
+		if !succeeded[t.Identifier] {
+			remaining.Task = tasks.Task[i:]
+			break
+		}
+	}
+	if len(remaining.Task) == 0 {
+		Panic("The failed actions of workflow %s are no longer part of it\n", workflow)
+	}
+	failed := remaining.Task[0].Identifier
+
+	task := createTask(remaining, repo)
+	line := createPipeline(remaining, workflow, repo)
+
+	// the Task and Pipeline get their own names so that the ones of the full workflow are kept
+	name := workflow + "-from-" + failed
+	original := task.Name
+	task.Name = generateName(kindTask, name)
+	line.Name = generateName(kindPipeline, name+"-pipeline")
+	for i := range line.Spec.Tasks {
+		if line.Spec.Tasks[i].TaskRef.Name == original {
+			line.Spec.Tasks[i].Name = task.Name
+			line.Spec.Tasks[i].TaskRef.Name = task.Name
+		}
+	}
+
+	objects := []runtime.Object{&task, &line}
+	checkObjects(objects)
+
+	a := applierFor(clientSet)
+	if err := a.applyWorkflow(workflow, objects, false); err != nil {
+		a.rollback()
+		Panic("Unable to apply workflow %s from action %s: %s\n", workflow, failed, err)
+	}
+
+	return remaining, task, line
+}
+
+// buildFailures returns a failed outcome for the first action using each image whose build task
+// failed in a PipelineRun
+func buildFailures(tasks Tasks, pr *pipeline.PipelineRun) []ActionOutcome {
+	failed := make(map[string]bool)
+	for _, tr := range pr.Status.TaskRuns {
+		if tr.Status != nil && runOutcome(tr.Status.GetCondition(apis.ConditionSucceeded)) == outcomeFailed {
+			failed[tr.PipelineTaskName] = true
+		}
+	}
+
+	outcomes := make([]ActionOutcome, 0)
+	for _, image := range taskImages(tasks) {
+		if !failed[generateName(kindTask, "build-"+image.BuildTaskName)] {
+			continue
+		}
+
+		for _, t := range tasks.Task {
+			if t.Image == image {
+				outcomes = append(outcomes, ActionOutcome{Action: t.Identifier, Status: outcomeFailed})
+				break
+			}
+		}
+	}
+
+	return outcomes
+}
+
+// skippedOutcomes returns succeeded outcomes for the actions skipped by a --from-failed run of the
+// named Task or Pipeline, as they succeeded in the run it was a rerun of
+func skippedOutcomes(workflow string, tasks Tasks, name string) []ActionOutcome {
+	for i, t := range tasks.Task {
+		from := workflow + "-from-" + t.Identifier
+		if name != convertName(from) && name != convertName(from+"-pipeline") {
+			continue
+		}
+
+		outcomes := make([]ActionOutcome, 0, i)
+		for _, skipped := range tasks.Task[:i] {
+			outcomes = append(outcomes, ActionOutcome{Action: skipped.Identifier, Status: outcomeSucceeded})
+		}
+		return outcomes
+	}
+
+	return nil
+}
+
+// declaredResources keeps the bindings of the input resources the Task still declares
+func declaredResources(task pipeline.Task, bindings []pipeline.TaskResourceBinding) []pipeline.TaskResourceBinding {
+	declared := make(map[string]bool)
+	if task.Spec.Inputs != nil {
+		for _, r := range task.Spec.Inputs.Resources {
+			declared[r.Name] = true
+		}
+	}
+
+	kept := make([]pipeline.TaskResourceBinding, 0, len(bindings))
+	for _, b := range bindings {
+		if declared[b.Name] {
+			kept = append(kept, b)
+		}
+	}
+
+	return kept
+}
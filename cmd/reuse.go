@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Files the steps of a build task share to decide whether the image is reused
+const (
+	// contextHashFile holds the hash of the files of the build context and of how they are built
+	contextHashFile = "/builder/home/context-hash"
+	// imageTagFile holds the content-addressed tag, the commit of the action followed by the context hash
+	imageTagFile = "/builder/home/image-tag"
+	// reusedMarker exists once an image built from the same context was found in the registry
+	reusedMarker = "/builder/home/image-reused"
+)
+
+// Images of the steps that tag and look up the images
+const (
+	gitImage   = "alpine/git"
+	craneImage = "gcr.io/go-containerregistry/crane:debug"
+)
+
+// skipScript runs the wrapped command unless the image was reused. The step name is passed as $0.
+const skipScript = `if [ -f ` + reusedMarker + ` ]; then echo "Image reused, skipping $0"; else exec "$@"; fi`
+
+var rebuildImages bool
+
+// reuseSteps surrounds the steps of a build template with the steps that tag the image with its
+// commit and context hash. When an image with the same context hash is already in the registry, it
+// is tagged with the tag of the run and the build steps are skipped. The hash covers the build options.
+func reuseSteps(image Image, steps []pipeline.Step, volumes []corev1.Volume) ([]pipeline.Step, []corev1.Volume) {
+	env, mounts, credentials := registryCredentials(dockerConfigDir, "DOCKER_CONFIG", "")
+	for _, c := range credentials {
+		if !hasVolume(volumes, c.Name) {
+			volumes = append(volumes, c)
+		}
+	}
+
+	name := image.BuildTaskName
+	crane := "crane"
+	if insecureRegistry(imageRegistry(image)) {
+		crane += " --insecure"
+	}
+
+	hash := corev1.Container{
+		Name:    generateName(kindStep, "hash-"+name),
+		Image:   gitImage,
+		Command: []string{"sh", "-c"},
+		Args: []string{`cd ` + buildContext + ` && commit=` + "`git rev-parse --short=12 HEAD`" + ` && ` +
+			`context=` + "`" + `{ echo ` + buildDockerfile + ` ` + buildOptions(image).hash() + `; find . -path ./.git -prune -o -type f -print | LC_ALL=C sort | ` +
+			`while read f; do sha256sum "$f"; done; } | sha256sum | cut -c1-16` + "`" + ` && ` +
+			`echo $context > ` + contextHashFile + ` && echo $commit-$context > ` + imageTagFile + ` && ` +
+			`echo "Content tag $commit-$context"`},
+	}
+
+	check := corev1.Container{
+		Name:    generateName(kindStep, "check-"+name),
+		Image:   craneImage,
+		Command: []string{"/busybox/sh", "-c"},
+		Args: []string{`context=` + "`cat " + contextHashFile + "`" + ` && ` +
+			`existing=` + "`" + crane + ` ls ` + buildRepository + ` 2>/dev/null | grep -e "-$context\$" | head -n 1` + "`" + `; ` +
+			`if [ -z "$existing" ]; then echo "No image of ` + buildRepository + ` built from context $context"; exit 0; fi; ` +
+			`echo "Reusing ` + buildRepository + `:$existing" && ` +
+			crane + ` copy ` + buildRepository + `:$existing ` + buildDestination + ` && ` +
+			indexScript(crane+` digest `+buildRepository+`:$existing`) + ` && ` +
+			`touch ` + reusedMarker},
+		Env:          env,
+		VolumeMounts: mounts,
+	}
+
+	// the run's tag is only pushed by this task, so it still holds the image it built
+	tag := corev1.Container{
+		Name:         generateName(kindStep, "tag-"+name),
+		Image:        craneImage,
+		Command:      []string{"/busybox/sh", "-c"},
+		Args:         []string{crane + ` copy ` + buildDestination + ` ` + buildRepository + `:` + "`cat " + imageTagFile + "`"},
+		Env:          env,
+		VolumeMounts: mounts,
+	}
+
+	reused := []pipeline.Step{{hash}, {check}}
+	for _, s := range steps {
+		reused = append(reused, skipWhenReused(s))
+	}
+	reused = append(reused, skipWhenReused(pipeline.Step{tag}))
+
+	return reused, volumes
+}
+
+// skipWhenReused wraps the command of a step in a shell that skips it when the image was reused
+func skipWhenReused(step pipeline.Step) pipeline.Step {
+	shell := "sh"
+	if step.Image == kanikoImage || step.Image == craneImage {
+		shell = "/busybox/sh"
+	}
+
+	args := append([]string{skipScript, step.Name}, step.Command...)
+	step.Command = []string{shell, "-c"}
+	step.Args = append(args, step.Args...)
+
+	return step
+}
+
+// hasVolume reports whether volumes has one with the name
+func hasVolume(volumes []corev1.Volume, name string) bool {
+	for _, v := range volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
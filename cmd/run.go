@@ -0,0 +1,256 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/actions/workflow-parser/model"
+	"github.com/spf13/cobra"
+
+	"github.com/triggermesh/aktion/pkg/client"
+
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"knative.dev/pkg/apis"
+)
+
+// commitPattern matches a full git commit SHA
+var commitPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+//NewRunCmd creates run command
+func NewRunCmd(kubeConfig *string, ns *string, gitRepository *string) *cobra.Command {
+	runCmd := &cobra.Command{
+		Use:   "run [workflow]",
+		Short: "Apply a workflow, start a PipelineRun and follow its logs until it completes",
+		Long: "Apply a workflow, start a PipelineRun and follow its logs until it completes.\n" +
+			"Exits with status 1 when the PipelineRun fails.",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config := ParseData()
+			resetGeneration()
+			namespace = *ns
+			repo = *gitRepository
+
+			workflow := selectWorkflow(config, args)
+			tasks := extractTasks(workflow, config)
+			objects := generateObjects(tasks, workflow, config)
+
+			checkObjects(objects)
+
+			clientSet := newClientSet(*kubeConfig)
+			a := applierFor(clientSet)
+			if err := a.applyWorkflow(workflow, objects, false); err != nil {
+				a.rollback()
+				Panic("Unable to apply workflow %s: %s\n", workflow, err)
+			}
+
+			pr := createPipelineRun(tasks, workflow, repo, workflow)
+			pr.Labels[labelTrigger] = triggerManual
+			if commit := workflowRevision(objects, workflow); commit != "" {
+				pr.Annotations[annotationCommit] = commit
+			}
+
+			created, err := clientSet.Pipeline.TektonV1alpha1().PipelineRuns(namespace).Create(&pr)
+			if err != nil {
+				Panic("Unable to create pipeline run: %s\n", err)
+			}
+			fmt.Printf("pipelinerun/%s created\n", created.Name)
+
+			printer := &logPrinter{
+				core:      clientSet.CoreStream,
+				namespace: namespace,
+				follow:    true,
+				out:       os.Stdout,
+			}
+
+			finished := followPipelineRun(clientSet, created.Name, printer)
+			reportOutcome(kindPipelineRun, finished.Name, finished.Status.GetCondition(apis.ConditionSucceeded))
+		},
+	}
+
+	addGenerateFlags(runCmd)
+	runCmd.Flags().BoolVarP(&skipValidation, "skip-validation", "", false, "Do not validate the generated Tekton objects before applying them")
+
+	return runCmd
+}
+
+// selectWorkflow returns the workflow named in args, or the only workflow of the file
+func selectWorkflow(config *model.Configuration, args []string) string {
+	if len(args) > 0 {
+		if config.GetWorkflow(args[0]) == nil {
+			Panic("Workflow %q not found in %s\n", args[0], filename)
+		}
+		return args[0]
+	}
+
+	if len(config.Workflows) != 1 {
+		Panic("%s has %d workflows, specify which one to use\n", filename, len(config.Workflows))
+	}
+
+	return config.Workflows[0].Identifier
+}
+
+// workflowRevision returns the commit the revision of the workflow's git resource points at, empty
+// without --git or when it cannot be resolved
+func workflowRevision(objects []runtime.Object, workflow string) string {
+	for _, obj := range objects {
+		if r, ok := obj.(*pipeline.PipelineResource); ok && r.Name == convertName(workflow) {
+			return resolveRevision(resourceParam(r, "url"), resourceParam(r, "revision"))
+		}
+	}
+
+	return ""
+}
+
+// resolveRevision asks the remote repository for the commit a branch or tag points at, with the
+// local git credentials. A revision that already is a commit is returned as is.
+func resolveRevision(url string, revision string) string {
+	if commitPattern.MatchString(revision) {
+		return revision
+	}
+	if revision == "" {
+		revision = "master"
+	}
+
+	out, err := exec.Command("git", "ls-remote", url, revision, revision+"^{}").Output()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to resolve revision %s of %s, the run has no commit: %s\n", revision, url, err)
+		return ""
+	}
+
+	// a peeled annotated tag is listed after the tag itself and points at the commit
+	commit := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if commit == "" || strings.HasSuffix(fields[1], "^{}") {
+			commit = fields[0]
+		}
+	}
+	if commit == "" {
+		fmt.Fprintf(os.Stderr, "Revision %s not found in %s, the run has no commit\n", revision, url)
+	}
+
+	return commit
+}
+
+// resourceParam returns the value of a PipelineResource param, empty if it is not set
+func resourceParam(resource *pipeline.PipelineResource, name string) string {
+	for _, p := range resource.Spec.Params {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+
+	return ""
+}
+
+// reportOutcome prints the outcome of a followed run and exits with status 1 unless it succeeded
+func reportOutcome(kind string, name string, cond *apis.Condition) {
+	if cond == nil || !cond.IsTrue() {
+		message := "unknown status"
+		if cond != nil {
+			message = cond.Message
+		}
+		Panic("%s %s failed: %s\n", kind, name, message)
+	}
+
+	fmt.Printf("%s %s succeeded\n", kind, name)
+}
+
+// followPipelineRun watches a PipelineRun, streaming the logs of each of its TaskRuns as their
+// pods are scheduled, and returns the PipelineRun once it has completed and all logs are written
+func followPipelineRun(clientSet client.ConfigSet, name string, printer *logPrinter) *pipeline.PipelineRun {
+	var wg sync.WaitGroup
+	streaming := make(map[string]bool)
+	pipelineRuns := clientSet.PipelineStream.TektonV1alpha1().PipelineRuns(namespace)
+
+	// stream starts following the logs of the pods of the run that are not followed yet
+	stream := func(pr *pipeline.PipelineRun) {
+		for _, tr := range pr.Status.TaskRuns {
+			if tr.Status == nil || tr.Status.PodName == "" || streaming[tr.Status.PodName] {
+				continue
+			}
+
+			streaming[tr.Status.PodName] = true
+			wg.Add(1)
+			go func(pod string) {
+				defer wg.Done()
+				if err := printer.printPod(pod); err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to stream logs of pod %s: %s\n", pod, err)
+				}
+			}(tr.Status.PodName)
+		}
+	}
+
+	for {
+		w, err := pipelineRuns.Watch(metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+		})
+		if err != nil {
+			Panic("Unable to watch pipeline run %s: %s\n", name, err)
+		}
+
+		for event := range w.ResultChan() {
+			if event.Type == watch.Error {
+				continue
+			} else if event.Type == watch.Deleted {
+				w.Stop()
+				wg.Wait()
+				Panic("PipelineRun %s was deleted before it completed\n", name)
+			}
+
+			pr, ok := event.Object.(*pipeline.PipelineRun)
+			if !ok {
+				continue
+			}
+
+			stream(pr)
+			if runCompleted(pr.Status.GetCondition(apis.ConditionSucceeded)) {
+				w.Stop()
+				wg.Wait()
+				return pr
+			}
+		}
+
+		// the server closes watches after a timeout, watch again unless the run is gone or completed
+		pr, err := pipelineRuns.Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			wg.Wait()
+			Panic("PipelineRun %s was deleted before it completed\n", name)
+		} else if err != nil {
+			Panic("Unable to get pipeline run %s: %s\n", name, err)
+		}
+		stream(pr)
+		if runCompleted(pr.Status.GetCondition(apis.ConditionSucceeded)) {
+			wg.Wait()
+			return pr
+		}
+	}
+}
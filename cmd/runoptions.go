@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+
+	"github.com/spf13/cobra"
+
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	runServiceAccountName   string
+	taskServiceAccountNames map[string]string
+	nodeSelector            map[string]string
+	runtimeClass            string
+)
+
+//RunOptions set the ServiceAccounts of runs and how their pods are scheduled and secured
+type RunOptions struct {
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// TaskServiceAccountNames are keyed by the generated names of the Pipeline tasks, see --name-map
+	TaskServiceAccountNames map[string]string    `json:"taskServiceAccountNames,omitempty"`
+	PodTemplate             pipeline.PodTemplate `json:"podTemplate,omitempty"`
+}
+
+// addRunFlags adds the flags setting the ServiceAccounts and pod template of runs
+func addRunFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&runServiceAccountName, "service-account", "", "", "ServiceAccount the runs of the workflows run as")
+	cmd.Flags().StringToStringVarP(&taskServiceAccountNames, "task-service-account", "", nil, "ServiceAccount of a single task of the Pipeline, as task=account, can be repeated")
+	cmd.Flags().StringToStringVarP(&nodeSelector, "node-selector", "", nil, "Node label the pods of runs are scheduled on, as key=value, can be repeated")
+	cmd.Flags().StringVarP(&runtimeClass, "runtime-class", "", "", "RuntimeClass the pods of runs are run with")
+}
+
+// runOptions returns the runs section of the config file, overridden by the flags that were set
+func runOptions() RunOptions {
+	options := aktionConfig.Runs
+	options.PodTemplate = *aktionConfig.Runs.PodTemplate.DeepCopy()
+
+	options.TaskServiceAccountNames = make(map[string]string)
+	for task, account := range aktionConfig.Runs.TaskServiceAccountNames {
+		options.TaskServiceAccountNames[task] = account
+	}
+	for task, account := range taskServiceAccountNames {
+		options.TaskServiceAccountNames[task] = account
+	}
+
+	if runServiceAccountName != "" {
+		options.ServiceAccountName = runServiceAccountName
+	}
+	if len(nodeSelector) > 0 {
+		options.PodTemplate.NodeSelector = nodeSelector
+	}
+	if runtimeClass != "" {
+		options.PodTemplate.RuntimeClassName = &runtimeClass
+	}
+
+	return options
+}
+
+// taskServiceAccounts returns the ServiceAccounts of single tasks of the workflow's Pipeline. Build
+// tasks run as --build-service-account unless the options name another account for them.
+func taskServiceAccounts(tasks Tasks, options RunOptions) []pipeline.PipelineRunSpecServiceAccount {
+	accounts := make([]pipeline.PipelineRunSpecServiceAccount, 0)
+
+	for _, v := range taskImages(tasks) {
+		task := generateName(kindTask, "build-"+v.BuildTaskName)
+		account := options.TaskServiceAccountNames[task]
+		if account == "" {
+			account = buildServiceAccount
+		}
+		if account != "" {
+			accounts = append(accounts, pipeline.PipelineRunSpecServiceAccount{TaskName: task, ServiceAccount: account})
+		}
+	}
+
+	task := generateName(kindTask, tasks.Identifier)
+	if account := options.TaskServiceAccountNames[task]; account != "" {
+		accounts = append(accounts, pipeline.PipelineRunSpecServiceAccount{TaskName: task, ServiceAccount: account})
+	}
+
+	return accounts
+}
+
+// transceiverRunEnv returns the environment telling the transceiver which ServiceAccount and pod
+// template the TaskRuns of a Task get
+func transceiverRunEnv(taskname string) []corev1.EnvVar {
+	options := runOptions()
+	env := make([]corev1.EnvVar, 0)
+
+	account := options.ServiceAccountName
+	if a := options.TaskServiceAccountNames[taskname]; a != "" {
+		account = a
+	}
+	if account != "" {
+		env = append(env, corev1.EnvVar{Name: "SERVICE_ACCOUNT", Value: account})
+	}
+
+	if data, err := json.Marshal(options.PodTemplate); err != nil {
+		Panic("Error generating the pod template of %s: %s\n", taskname, err)
+	} else if string(data) != "{}" {
+		env = append(env, corev1.EnvVar{Name: "POD_TEMPLATE", Value: string(data)})
+	}
+
+	return env
+}
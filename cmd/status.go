@@ -0,0 +1,336 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/actions/workflow-parser/model"
+	"github.com/spf13/cobra"
+
+	"github.com/triggermesh/aktion/pkg/client"
+
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+// Outcomes of runs and of the actions they run
+const (
+	outcomePending   = "Pending"
+	outcomeRunning   = "Running"
+	outcomeSucceeded = "Succeeded"
+	outcomeFailed    = "Failed"
+	outcomeCancelled = "Cancelled"
+)
+
+// outputTable selects the table output of status and history, which is also their default
+const outputTable = "table"
+
+// commitLength is the number of characters of a commit shown in tables
+const commitLength = 7
+
+var historyLimit int
+
+//RunSummary describes a PipelineRun or TaskRun of a workflow
+type RunSummary struct {
+	Workflow  string          `json:"workflow"`
+	Kind      string          `json:"kind"`
+	Name      string          `json:"name"`
+	Trigger   string          `json:"trigger,omitempty"`
+	Commit    string          `json:"commit,omitempty"`
+	Status    string          `json:"status"`
+	StartTime *metav1.Time    `json:"startTime,omitempty"`
+	Duration  string          `json:"duration,omitempty"`
+	Actions   []ActionOutcome `json:"actions,omitempty"`
+
+	created metav1.Time
+}
+
+//ActionOutcome is the outcome of a single action of a run
+type ActionOutcome struct {
+	Action string `json:"action"`
+	Status string `json:"status"`
+}
+
+//NewStatusCmd creates status command
+func NewStatusCmd(kubeConfig *string, ns *string, gitRepository *string) *cobra.Command {
+	statusCmd := &cobra.Command{
+		Use:   "status [workflow...]",
+		Short: "Show the latest run of each workflow",
+		Long: "Show the latest PipelineRun or TaskRun of each workflow, whether it was started by run or\n" +
+			"by the transceiver. Prints a table unless --output is json or yaml.",
+		Run: func(cmd *cobra.Command, args []string) {
+			config := ParseData()
+			clientSet := newClientSet(*kubeConfig)
+			namespace = *ns
+			repo = *gitRepository
+
+			latest := make([]RunSummary, 0)
+			for _, workflow := range statusWorkflows(config, args) {
+				if runs := workflowRuns(clientSet, workflow); len(runs) > 0 {
+					latest = append(latest, runs[0])
+				}
+			}
+
+			printRuns(cmd, latest)
+		},
+	}
+
+	return statusCmd
+}
+
+//NewHistoryCmd creates history command
+func NewHistoryCmd(kubeConfig *string, ns *string, gitRepository *string) *cobra.Command {
+	historyCmd := &cobra.Command{
+		Use:   "history [workflow...]",
+		Short: "List the recent runs of workflows, newest first",
+		Long: "List the recent PipelineRuns and TaskRuns of workflows, newest first, whether they were\n" +
+			"started by run or by the transceiver. Prints a table unless --output is json or yaml.",
+		Run: func(cmd *cobra.Command, args []string) {
+			config := ParseData()
+			clientSet := newClientSet(*kubeConfig)
+			namespace = *ns
+			repo = *gitRepository
+
+			runs := make([]RunSummary, 0)
+			for _, workflow := range statusWorkflows(config, args) {
+				runs = append(runs, workflowRuns(clientSet, workflow)...)
+			}
+
+			sortRuns(runs)
+			if historyLimit > 0 && len(runs) > historyLimit {
+				runs = runs[:historyLimit]
+			}
+
+			printRuns(cmd, runs)
+		},
+	}
+
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "", 10, "Maximum number of runs to list, 0 for all")
+
+	return historyCmd
+}
+
+// statusWorkflows returns the workflows named in args, or every workflow of the file. The workflows
+// are generated so that step names can be mapped back to their actions.
+func statusWorkflows(config *model.Configuration, args []string) []string {
+	resetGeneration()
+
+	workflows := args
+	if len(workflows) == 0 {
+		for _, w := range config.Workflows {
+			workflows = append(workflows, w.Identifier)
+		}
+	}
+
+	for _, w := range workflows {
+		if config.GetWorkflow(w) == nil {
+			Panic("Workflow %q not found in %s\n", w, filename)
+		}
+		generateObjects(extractTasks(w, config), w, config)
+	}
+
+	return workflows
+}
+
+// workflowRuns returns the PipelineRuns of a workflow and the TaskRuns started for it outside of a
+// PipelineRun, newest first
+func workflowRuns(clientSet client.ConfigSet, workflow string) []RunSummary {
+	tekton := clientSet.Pipeline.TektonV1alpha1()
+	runs := make([]RunSummary, 0)
+
+	pipelineRuns, err := tekton.PipelineRuns(namespace).List(metav1.ListOptions{LabelSelector: workflowSelector(workflow)})
+	if err != nil {
+		Panic("Unable to list pipeline runs: %s\n", err)
+	}
+	for i := range pipelineRuns.Items {
+		runs = append(runs, pipelineRunSummary(workflow, &pipelineRuns.Items[i]))
+	}
+
+	selector := workflowSelector(workflow)
+	taskRuns, err := tekton.TaskRuns(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		Panic("Unable to list task runs: %s\n", err)
+	}
+	for i := range taskRuns.Items {
+		if _, ok := taskRuns.Items[i].Labels[tektonPipelineRunLabel]; ok {
+			continue
+		}
+		runs = append(runs, taskRunSummary(workflow, &taskRuns.Items[i]))
+	}
+
+	sortRuns(runs)
+
+	return runs
+}
+
+// pipelineRunSummary summarises a PipelineRun, its actions ordered by the start of their TaskRun
+func pipelineRunSummary(workflow string, pr *pipeline.PipelineRun) RunSummary {
+	actions := make([]ActionOutcome, 0)
+	for _, s := range taskRunStatuses(pr) {
+		actions = append(actions, stepOutcomes(s.Steps)...)
+	}
+
+	return RunSummary{
+		Workflow:  workflow,
+		Kind:      kindPipelineRun,
+		Name:      pr.Name,
+		Trigger:   pr.Labels[labelTrigger],
+		Commit:    pr.Annotations[annotationCommit],
+		Status:    runOutcome(pr.Status.GetCondition(apis.ConditionSucceeded)),
+		StartTime: pr.Status.StartTime,
+		Duration:  runDuration(pr.Status.StartTime, pr.Status.CompletionTime),
+		Actions:   actions,
+		created:   pr.CreationTimestamp,
+	}
+}
+
+// taskRunSummary summarises a TaskRun created outside of a PipelineRun
+func taskRunSummary(workflow string, tr *pipeline.TaskRun) RunSummary {
+	return RunSummary{
+		Workflow:  workflow,
+		Kind:      "TaskRun",
+		Name:      tr.Name,
+		Trigger:   tr.Labels[labelTrigger],
+		Commit:    tr.Annotations[annotationCommit],
+		Status:    runOutcome(tr.Status.GetCondition(apis.ConditionSucceeded)),
+		StartTime: tr.Status.StartTime,
+		Duration:  runDuration(tr.Status.StartTime, tr.Status.CompletionTime),
+		Actions:   stepOutcomes(tr.Status.Steps),
+		created:   tr.CreationTimestamp,
+	}
+}
+
+// stepOutcomes returns the outcome of the steps that run a workflow action, skipping the steps
+// Tekton and the build tasks add
+func stepOutcomes(steps []pipeline.StepState) []ActionOutcome {
+	outcomes := make([]ActionOutcome, 0, len(steps))
+
+	for _, s := range steps {
+		action, ok := generatedNames[kindAction][strings.TrimPrefix(s.Name, stepContainerPrefix)]
+		if !ok {
+			continue
+		}
+
+		outcome := outcomePending
+		if s.Terminated != nil && s.Terminated.ExitCode == 0 {
+			outcome = outcomeSucceeded
+		} else if s.Terminated != nil {
+			outcome = outcomeFailed
+		} else if s.Running != nil {
+			outcome = outcomeRunning
+		}
+
+		outcomes = append(outcomes, ActionOutcome{Action: action, Status: outcome})
+	}
+
+	return outcomes
+}
+
+// runOutcome maps the Succeeded condition of a run to its outcome
+func runOutcome(cond *apis.Condition) string {
+	if cond == nil {
+		return outcomePending
+	} else if cond.IsTrue() {
+		return outcomeSucceeded
+	} else if cond.IsFalse() && strings.HasSuffix(cond.Reason, outcomeCancelled) {
+		return outcomeCancelled
+	} else if cond.IsFalse() {
+		return outcomeFailed
+	}
+
+	return outcomeRunning
+}
+
+// runDuration returns how long a run took, or has been running for when it has not completed
+func runDuration(start *metav1.Time, completion *metav1.Time) string {
+	if start == nil {
+		return ""
+	}
+
+	end := time.Now()
+	if completion != nil {
+		end = completion.Time
+	}
+
+	return end.Sub(start.Time).Round(time.Second).String()
+}
+
+// sortRuns orders runs newest first
+func sortRuns(runs []RunSummary) {
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[j].created.Before(&runs[i].created)
+	})
+}
+
+// printRuns writes runs as a table, or in the json or yaml output format when one was asked for
+func printRuns(cmd *cobra.Command, runs []RunSummary) {
+	if outputType != outputTable && cmd.Flags().Changed("output") {
+		fmt.Print(GenerateOutput(runs))
+		return
+	}
+
+	if len(runs) == 0 {
+		fmt.Println("No runs found")
+		return
+	}
+
+	printRunTable(os.Stdout, runs)
+}
+
+// printRunTable writes one row per run with the outcome of each of its actions
+func printRunTable(w io.Writer, runs []RunSummary) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKFLOW\tRUN\tTRIGGER\tCOMMIT\tSTATUS\tSTARTED\tDURATION\tACTIONS")
+
+	for _, r := range runs {
+		started := "-"
+		if r.StartTime != nil {
+			started = r.StartTime.Local().Format("2006-01-02 15:04:05")
+		}
+
+		commit := r.Commit
+		if len(commit) > commitLength {
+			commit = commit[:commitLength]
+		}
+
+		actions := make([]string, 0, len(r.Actions))
+		for _, a := range r.Actions {
+			actions = append(actions, a.Action+": "+a.Status)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s/%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Workflow, strings.ToLower(r.Kind), r.Name,
+			orDash(r.Trigger), orDash(commit), r.Status, started, orDash(r.Duration), orDash(strings.Join(actions, ", ")))
+	}
+	_ = tw.Flush()
+}
+
+// orDash returns a dash for empty table cells
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
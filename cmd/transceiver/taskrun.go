@@ -16,8 +16,12 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"reflect"
+	"strings"
 	"time"
 
 	yaml "github.com/ghodss/yaml"
@@ -30,9 +34,31 @@ import (
 	rest "k8s.io/client-go/rest"
 )
 
+// Labels and annotations read by aktion status and history, they must match cmd/labels.go
+const (
+	labelManagedBy   = "app.kubernetes.io/managed-by"
+	labelWorkflow    = "aktion.triggermesh.io/workflow"
+	labelTrigger     = "aktion.triggermesh.io/trigger"
+	annotationCommit = "aktion.triggermesh.io/commit"
+
+	managedByAktion = "aktion"
+	// defaultTrigger is recorded when the request does not say which event it carries
+	defaultTrigger = "webhook"
+)
+
 //TaskRunCreator handles TaskRun objects creation
 type TaskRunCreator struct{}
 
+// githubPayload holds the fields of GitHub event payloads identifying the commit to build
+type githubPayload struct {
+	After       string `json:"after"`
+	PullRequest *struct {
+		Head struct {
+			Sha string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+}
+
 func main() {
 	log.Info("Start server at port :8080 ")
 	http.ListenAndServe(":8080", TaskRunCreator{})
@@ -41,7 +67,8 @@ func main() {
 func (trc TaskRunCreator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
-	out, err := createTaskRuns()
+	trigger, commit := parseEvent(r)
+	out, err := createTaskRuns(trigger, commit)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -50,7 +77,35 @@ func (trc TaskRunCreator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
-func createTaskRuns() ([]byte, error) {
+// parseEvent returns the type of the event carried by the request and the commit it refers to
+func parseEvent(r *http.Request) (string, string) {
+	trigger := defaultTrigger
+	if t := r.Header.Get("Ce-Type"); t != "" {
+		// GitHub source event types look like dev.knative.source.github.push
+		trigger = t[strings.LastIndex(t, ".")+1:]
+	} else if t := r.Header.Get("X-GitHub-Event"); t != "" {
+		trigger = t
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Warnf("Unable to read request body: %s", err)
+		return trigger, ""
+	}
+
+	var payload githubPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return trigger, ""
+	}
+
+	if payload.PullRequest != nil {
+		return trigger, payload.PullRequest.Head.Sha
+	}
+
+	return trigger, payload.After
+}
+
+func createTaskRuns(trigger string, commit string) ([]byte, error) {
 	namespace := os.Getenv("NAMESPACE")
 
 	c, err := rest.InClusterConfig()
@@ -93,6 +148,13 @@ func createTaskRuns() ([]byte, error) {
 	// iterate over taskruns list and create its items
 	var result []*v1alpha1.TaskRun
 	for _, taskrun := range taskruns {
+		setRunMetadata(&taskrun, trigger, commit)
+		if err := setRunOptions(&taskrun); err != nil {
+			return []byte{}, err
+		}
+		if err := bindResources(tekton, namespace, &taskrun); err != nil {
+			return []byte{}, err
+		}
 		tr, err := tekton.TaskRuns(namespace).Create(&taskrun)
 		if err != nil {
 			return []byte{}, err
@@ -116,6 +178,76 @@ func taskrunsFromConfigmaps(namespace string, configmap *corev1.ConfigMap) ([]v1
 	return res, nil
 }
 
+// setRunMetadata labels a TaskRun so that aktion can tell which workflow and event it ran for
+func setRunMetadata(taskrun *v1alpha1.TaskRun, trigger string, commit string) {
+	if taskrun.Labels == nil {
+		taskrun.Labels = make(map[string]string)
+	}
+	if taskrun.Annotations == nil {
+		taskrun.Annotations = make(map[string]string)
+	}
+
+	taskrun.Labels[labelManagedBy] = managedByAktion
+	taskrun.Labels[labelTrigger] = trigger
+	if taskrun.Spec.TaskRef != nil {
+		taskrun.Labels[labelWorkflow] = taskrun.Spec.TaskRef.Name
+	}
+	if commit != "" {
+		taskrun.Annotations[annotationCommit] = commit
+	}
+}
+
+// setRunOptions gives a TaskRun the ServiceAccount and pod template set with the SERVICE_ACCOUNT
+// and POD_TEMPLATE env variables, unless its definition in the configmap already has them
+func setRunOptions(taskrun *v1alpha1.TaskRun) error {
+	if account, ok := os.LookupEnv("SERVICE_ACCOUNT"); ok && taskrun.Spec.ServiceAccount == "" {
+		taskrun.Spec.ServiceAccount = account
+	}
+
+	if template, ok := os.LookupEnv("POD_TEMPLATE"); ok && reflect.DeepEqual(taskrun.Spec.PodTemplate, v1alpha1.PodTemplate{}) {
+		if err := json.Unmarshal([]byte(template), &taskrun.Spec.PodTemplate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bindResources binds the input resources the referenced Task declares and the TaskRun does not
+// bind yet to the PipelineResources of the same name, which is how aktion names them
+func bindResources(tekton *tektonv1alpha1.TektonV1alpha1Client, namespace string, taskrun *v1alpha1.TaskRun) error {
+	if taskrun.Spec.TaskRef == nil || taskrun.Spec.TaskRef.Name == "" {
+		return nil
+	}
+
+	task, err := tekton.Tasks(namespace).Get(taskrun.Spec.TaskRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if task.Spec.Inputs == nil {
+		return nil
+	}
+
+	bound := make(map[string]bool)
+	for _, b := range taskrun.Spec.Inputs.Resources {
+		bound[b.Name] = true
+	}
+
+	for _, r := range task.Spec.Inputs.Resources {
+		if bound[r.Name] {
+			continue
+		}
+		taskrun.Spec.Inputs.Resources = append(taskrun.Spec.Inputs.Resources, v1alpha1.TaskResourceBinding{
+			Name: r.Name,
+			ResourceRef: v1alpha1.PipelineResourceRef{
+				Name: r.Name,
+			},
+		})
+	}
+
+	return nil
+}
+
 func taskRunWithTaskRef(namespace string, taskRef string) v1alpha1.TaskRun {
 	return v1alpha1.TaskRun{
 		TypeMeta: metav1.TypeMeta{
@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2019 TriggerMesh, Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/apis"
+)
+
+// validateObjects runs Tekton's defaulting and validation on copies of the generated Tasks,
+// Pipelines and PipelineRuns and returns an error message per invalid object
+func validateObjects(objects []runtime.Object) []string {
+	ctx := context.Background()
+	failures := make([]string, 0)
+
+	for _, obj := range objects {
+		var name string
+		var err *apis.FieldError
+
+		switch o := obj.(type) {
+		case *pipeline.Task:
+			t := o.DeepCopy()
+			t.SetDefaults(ctx)
+			name, err = t.Name, t.Validate(ctx)
+		case *pipeline.Pipeline:
+			p := o.DeepCopy()
+			p.SetDefaults(ctx)
+			name, err = p.Name, p.Validate(ctx)
+		case *pipeline.PipelineRun:
+			pr := o.DeepCopy()
+			// runs are named by the server, validate them with their name prefix
+			if pr.Name == "" {
+				pr.Name = strings.TrimSuffix(pr.GenerateName, "-")
+			}
+			pr.SetDefaults(ctx)
+			name, err = pr.Name, pr.Validate(ctx)
+		default:
+			continue
+		}
+
+		if err != nil {
+			kind := strings.ToLower(obj.GetObjectKind().GroupVersionKind().Kind)
+			failures = append(failures, fmt.Sprintf("%s/%s: %s", kind, name, err.Error()))
+		}
+	}
+
+	return failures
+}
+
+// checkObjects panics when one of the generated objects is invalid, unless --skip-validation is set
+func checkObjects(objects []runtime.Object) {
+	if skipValidation {
+		return
+	}
+
+	if failures := validateObjects(objects); len(failures) > 0 {
+		Panic("Generated objects are invalid, use --skip-validation to ignore:\n  %s\n", strings.Join(failures, "\n  "))
+	}
+}
@@ -18,24 +18,52 @@ package client
 
 import (
 	"os"
+	"time"
+
 	pipelineApi "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
-	// gcp package is required for kubectl configs with GCP auth providers
+	// register the Azure, GCP and OIDC auth providers used in kubectl configs, exec credential
+	// plugins are supported by client-go itself
+	_ "k8s.io/client-go/plugin/pkg/client/auth/azure"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 )
 
 //ConfigSet contains configurations from available configuration file or from in-cluster environment
 type ConfigSet struct {
 	Core     *kubernetes.Clientset
 	Pipeline *pipelineApi.Clientset
+	Dynamic  dynamic.Interface
+
+	// CoreStream and PipelineStream have no request timeout, for watches and log streams that last
+	// as long as the runs they follow
+	CoreStream     *kubernetes.Clientset
+	PipelineStream *pipelineApi.Clientset
 
 	Config *rest.Config
 }
 
+//Options describe how to connect to the cluster. Zero values keep the kubeconfig and client-go defaults.
+type Options struct {
+	// ConfigFile is the kubeconfig file, the KUBECONFIG variable and ~/.kube/config are used when empty
+	ConfigFile string
+	// Context is the kubeconfig context to use instead of the current one
+	Context string
+	// Impersonate and ImpersonateGroups are the user and groups to act as
+	Impersonate       string
+	ImpersonateGroups []string
+	// QPS and Burst limit the rate of requests to the API server
+	QPS   float32
+	Burst int
+	// Timeout is the maximum duration of a single request, watches and log streams are not limited
+	Timeout time.Duration
+}
+
 //ConfigPath returns path to a valid config file
 func ConfigPath(cfgFile string) string {
 	homeDir := "."
@@ -56,12 +84,35 @@ func ConfigPath(cfgFile string) string {
 
 // NewClient returns ConfigSet created from available configuration file or from in-cluster environment
 func NewClient(cfgFile string) (ConfigSet, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", cfgFile)
+	return NewClientWithOptions(Options{ConfigFile: cfgFile})
+}
+
+//NewClientWithOptions returns ConfigSet created from the kubeconfig selected by the options, or from the
+//in-cluster environment when no kubeconfig exists
+func NewClientWithOptions(opts Options) (ConfigSet, error) {
+	config, err := restConfig(opts)
+	if err != nil {
+		return ConfigSet{}, err
+	}
 
 	c := ConfigSet{
 		Config: config,
 	}
 
+	if c.PipelineStream, err = pipelineApi.NewForConfig(config); err != nil {
+		return c, err
+	}
+
+	if c.CoreStream, err = kubernetes.NewForConfig(config); err != nil {
+		return c, err
+	}
+
+	if opts.Timeout > 0 {
+		config = rest.CopyConfig(config)
+		config.Timeout = opts.Timeout
+		c.Config = config
+	}
+
 	if c.Pipeline, err = pipelineApi.NewForConfig(config); err != nil {
 		return c, err
 	}
@@ -70,5 +121,42 @@ func NewClient(cfgFile string) (ConfigSet, error) {
 		return c, err
 	}
 
+	if c.Dynamic, err = dynamic.NewForConfig(config); err != nil {
+		return c, err
+	}
+
 	return c, nil
 }
+
+// restConfig loads the kubeconfig with the context and impersonation overrides. The loader falls
+// back to the in-cluster config when there is no kubeconfig at all.
+func restConfig(opts Options) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = opts.ConfigFile
+
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: opts.Context,
+	}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// the in-cluster config ignores the overrides, so impersonation is set on the result
+	if opts.Impersonate != "" || len(opts.ImpersonateGroups) > 0 {
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: opts.Impersonate,
+			Groups:   opts.ImpersonateGroups,
+		}
+	}
+
+	if opts.QPS > 0 {
+		config.QPS = opts.QPS
+	}
+	if opts.Burst > 0 {
+		config.Burst = opts.Burst
+	}
+
+	return config, nil
+}